@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const eventHistorySize = 100
+
+type EventType string
+
+const (
+	EventStarted   EventType = "started"
+	EventRestarted EventType = "restarted"
+	EventStopped   EventType = "stopped"
+	EventExited    EventType = "exited"
+	EventError     EventType = "error"
+)
+
+type StreamEvent struct {
+	Time     time.Time     `json:"time"`
+	Type     EventType     `json:"type"`
+	ExitCode *int          `json:"exitcode,omitempty"`
+	Uptime   time.Duration `json:"uptime,omitempty"`
+	Message  string        `json:"message,omitempty"`
+}
+
+func (e StreamEvent) String() string {
+	str := string(e.Type)
+	if e.ExitCode != nil {
+		str += fmt.Sprintf(" with code %d", *e.ExitCode)
+	}
+	if e.Uptime > 0 {
+		str += fmt.Sprintf(" after %v", e.Uptime.Round(time.Second))
+	}
+	if len(e.Message) > 0 {
+		str += ": " + e.Message
+	}
+	return str
+}
+
+func exitCode(err error) *int {
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		return nil
+	}
+	return &code
+}
+
+type EventStore interface {
+	Add(name string, event *StreamEvent)
+	List(name string) []StreamEvent
+	Delete(name string)
+}
+
+type memoryEventStore struct {
+	mu     sync.Mutex
+	events map[string][]StreamEvent
+}
+
+func newMemoryEventStore() *memoryEventStore {
+	return &memoryEventStore{
+		events: make(map[string][]StreamEvent),
+	}
+}
+
+func (store *memoryEventStore) Add(name string, event *StreamEvent) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	events := append(store.events[name], *event)
+	if len(events) > eventHistorySize {
+		events = events[len(events)-eventHistorySize:]
+	}
+	store.events[name] = events
+}
+
+func (store *memoryEventStore) List(name string) []StreamEvent {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	events := store.events[name]
+	results := make([]StreamEvent, len(events))
+	for i := range events {
+		results[i] = events[len(events)-1-i]
+	}
+	return results
+}
+
+func (store *memoryEventStore) Delete(name string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.events, name)
+}
+
+type redisEventStore struct {
+	db *redis.Client
+}
+
+func eventsKey(name string) string {
+	return "events:" + name
+}
+
+func (store *redisEventStore) Add(name string, event *StreamEvent) {
+	eventStr, err := json.Marshal(event)
+	if err != nil {
+		log.Println("json error:", err)
+		return
+	}
+	ctx := context.Background()
+	_, err = store.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, eventsKey(name), string(eventStr))
+		pipe.LTrim(ctx, eventsKey(name), 0, eventHistorySize-1)
+		return nil
+	})
+	if err != nil {
+		log.Println("error while saving event to db:", err)
+	}
+}
+
+func (store *redisEventStore) List(name string) []StreamEvent {
+	eventStrs, err := store.db.LRange(context.Background(), eventsKey(name), 0, -1).Result()
+	if err != nil {
+		log.Println("db error:", err)
+		return nil
+	}
+	results := make([]StreamEvent, 0, len(eventStrs))
+	for _, eventStr := range eventStrs {
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(eventStr), &event); err != nil {
+			log.Println("json error:", err)
+			continue
+		}
+		results = append(results, event)
+	}
+	return results
+}
+
+func (store *redisEventStore) Delete(name string) {
+	if err := store.db.Del(context.Background(), eventsKey(name)).Err(); err != nil {
+		log.Println("error while deleting events from db:", err)
+	}
+}
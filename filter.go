@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type VideoFit string
+
+const (
+	FitScale VideoFit = "scale" // keep aspect ratio within the given size
+	FitPad   VideoFit = "pad"
+	FitCrop  VideoFit = "crop"
+)
+
+var fpsPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(/[0-9]+)?$`)
+
+var rotateFilters = map[string][]string{
+	"90":    {"transpose=clock"},
+	"180":   {"hflip", "vflip"},
+	"270":   {"transpose=cclock"},
+	"hflip": {"hflip"},
+	"vflip": {"vflip"},
+}
+
+func (entry *StreamEntry) validateVideoFilters() error {
+	if entry.Width < 0 || entry.Height < 0 || entry.Width%2 != 0 || entry.Height%2 != 0 {
+		return fmt.Errorf("invalid size: %dx%d", entry.Width, entry.Height)
+	}
+	switch entry.Fit {
+	case "", FitScale:
+	case FitPad, FitCrop:
+		if entry.Width == 0 || entry.Height == 0 {
+			return fmt.Errorf("%s needs both width and height", entry.Fit)
+		}
+	default:
+		return fmt.Errorf("invalid fit: %s", entry.Fit)
+	}
+	if len(entry.FPS) > 0 && !fpsPattern.MatchString(entry.FPS) {
+		return fmt.Errorf("invalid fps: %s", entry.FPS)
+	}
+	if _, ok := rotateFilters[entry.Rotate]; len(entry.Rotate) > 0 && !ok {
+		return fmt.Errorf("invalid rotation: %s", entry.Rotate)
+	}
+	return nil
+}
+
+// videoFilters builds the filter chain of the item in the order of
+// rotation, scaling (with padding or cropping), burned-in subtitles and frame rate
+func videoFilters(entry *StreamEntry, item *PlaylistItem) (filters []string) {
+	filters = append(filters, rotateFilters[entry.Rotate]...)
+	w, h := entry.Width, entry.Height
+	switch {
+	case w > 0 && h > 0 && entry.Fit == FitPad:
+		filters = append(filters,
+			fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", w, h),
+			fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", w, h),
+			"setsar=1")
+	case w > 0 && h > 0 && entry.Fit == FitCrop:
+		filters = append(filters,
+			fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase", w, h),
+			fmt.Sprintf("crop=%d:%d", w, h),
+			"setsar=1")
+	case w > 0 && h > 0:
+		filters = append(filters, fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease:force_divisible_by=2", w, h))
+	case w > 0:
+		filters = append(filters, fmt.Sprintf("scale=%d:-2", w))
+	case h > 0:
+		filters = append(filters, fmt.Sprintf("scale=-2:%d", h))
+	}
+	if item.SubtitleChannel >= 0 {
+		escapedSource := strings.ReplaceAll(item.Source, ":", "\\:")
+		filters = append(filters, fmt.Sprintf("subtitles='%s':stream_index=%d", escapedSource, item.SubtitleChannel))
+	}
+	if len(entry.FPS) > 0 {
+		filters = append(filters, "fps="+entry.FPS)
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type HLSMode string
+
+const (
+	HLSOff  HLSMode = "off"
+	HLSAlso HLSMode = "also" // in addition to the target
+	HLSOnly HLSMode = "only"
+
+	hlsPlaylist = "index.m3u8"
+)
+
+var hlsProtocol = &outputProtocol{
+	Format:      "hls",
+	Options:     []string{"hls_time", "2", "hls_list_size", "6", "hls_flags", "delete_segments+append_list+discont_start+omit_endlist"},
+	VideoCodecs: tsVideoCodecs,
+	AudioCodecs: tsAudioCodecs,
+}
+
+func (mode HLSMode) Validate(entry *StreamEntry) error {
+	switch mode {
+	case "", HLSOff, HLSAlso:
+		return nil
+	case HLSOnly:
+		if len(entry.Outputs) > 0 {
+			return fmt.Errorf("HLS only streams can't have additional outputs")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid HLS mode: %s", mode)
+	}
+}
+
+func (mode HLSMode) Enabled() bool {
+	return mode == HLSAlso || mode == HLSOnly
+}
+
+// hlsURL returns the path the HLS playlist of the stream is served at
+func hlsURL(name string) string {
+	return "/hls/" + name + "/" + hlsPlaylist
+}
+
+// resetHLSDir removes the segments left behind by a previous run
+func (stream *Stream) resetHLSDir() error {
+	if len(stream.hlsDir) == 0 {
+		return nil
+	}
+	if err := os.RemoveAll(stream.hlsDir); err != nil {
+		return err
+	}
+	return os.MkdirAll(stream.hlsDir, 0755)
+}
+
+func (stream *Stream) removeHLSDir() {
+	if len(stream.hlsDir) == 0 {
+		return
+	}
+	if err := os.RemoveAll(stream.hlsDir); err != nil {
+		log.Printf("[%s] failed to remove HLS segments: %v", stream.Name, err)
+	}
+}
+
+func hlsOptions(stream *Stream) []string {
+	options := append([]string(nil), hlsProtocol.Options...)
+	return append(options, "hls_segment_filename", filepath.Join(stream.hlsDir, "segment%05d.ts"))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logBufferSize = 500
+
+type LogLine struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// LogBuffer is a concurrency-safe ring buffer of the most recent output lines
+type LogBuffer struct {
+	mu       sync.Mutex
+	lines    []LogLine
+	next     int
+	total    int
+	partial  []byte
+	redactor *strings.Replacer
+}
+
+// NewLogBuffer creates a log buffer that passes the lines through the redactor (if not nil)
+func NewLogBuffer(size int, redactor *strings.Replacer) *LogBuffer {
+	return &LogBuffer{
+		lines:    make([]LogLine, 0, size),
+		redactor: redactor,
+	}
+}
+
+func (lb *LogBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.partial = append(lb.partial, p...)
+	for {
+		i := bytes.IndexByte(lb.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lb.add(strings.TrimRight(string(lb.partial[:i]), "\r"))
+		lb.partial = lb.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (lb *LogBuffer) Printf(format string, args ...any) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.add(fmt.Sprintf(format, args...))
+}
+
+func (lb *LogBuffer) add(text string) {
+	if len(text) == 0 {
+		return
+	}
+	if lb.redactor != nil {
+		text = lb.redactor.Replace(text)
+	}
+	line := LogLine{Time: time.Now(), Text: text}
+	if len(lb.lines) < cap(lb.lines) {
+		lb.lines = append(lb.lines, line)
+	} else {
+		lb.lines[lb.next] = line
+	}
+	lb.next = (lb.next + 1) % cap(lb.lines)
+	lb.total++
+}
+
+// Total returns the number of lines ever written to the buffer
+func (lb *LogBuffer) Total() int {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.total
+}
+
+func (lb *LogBuffer) Lines() []LogLine {
+	return lb.Since(0)
+}
+
+// Since returns the buffered lines written after the first n lines
+func (lb *LogBuffer) Since(n int) []LogLine {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	count := lb.total - n
+	if count > len(lb.lines) {
+		count = len(lb.lines)
+	}
+	if count <= 0 {
+		return nil
+	}
+	results := make([]LogLine, 0, count)
+	start := lb.next - count
+	if start < 0 {
+		start += len(lb.lines)
+	}
+	for i := 0; i < count; i++ {
+		results = append(results, lb.lines[(start+i)%len(lb.lines)])
+	}
+	return results
+}
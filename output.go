@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultOutputPath = "{target}/{name}"
+	maskedSecret      = "xxxxx"
+)
+
+type outputProtocol struct {
+	Format      string
+	Options     []string // muxer options as name, value pairs
+	VideoCodecs []string // supported codecs (any if nil)
+	AudioCodecs []string
+}
+
+var (
+	rtspProtocol = &outputProtocol{
+		Format:  "rtsp",
+		Options: []string{"rtsp_transport", "tcp", "auth_type", "digest"},
+	}
+	tsVideoCodecs = []string{"h264", "hevc", "mpeg2video"}
+	tsAudioCodecs = []string{"aac", "mp3", "mp2", "ac3", "eac3", "opus"}
+	srtProtocol   = &outputProtocol{
+		Format:      "mpegts",
+		VideoCodecs: tsVideoCodecs,
+		AudioCodecs: tsAudioCodecs,
+	}
+	udpProtocol = &outputProtocol{
+		Format:      "mpegts",
+		VideoCodecs: tsVideoCodecs,
+		AudioCodecs: tsAudioCodecs,
+	}
+	rtmpProtocol = &outputProtocol{
+		Format:      "flv",
+		Options:     []string{"flvflags", "no_duration_filesize"},
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "mp3"},
+	}
+)
+
+var outputProtocols = map[string]*outputProtocol{
+	"rtsp":  rtspProtocol,
+	"rtsps": rtspProtocol,
+	"rtmp":  rtmpProtocol,
+	"rtmps": rtmpProtocol,
+	"srt":   srtProtocol,
+	"udp":   udpProtocol,
+}
+
+var srtModes = []string{"caller", "listener"}
+
+// encoderCodecs maps the name of ffmpeg encoders to the codec they produce
+// (besides the hardware encoders like h264_nvenc that are named after the codec)
+var encoderCodecs = map[string]string{
+	"libx264":     "h264",
+	"libx264rgb":  "h264",
+	"libopenh264": "h264",
+	"libx265":     "hevc",
+	"libvpx":      "vp8",
+	"libvpx-vp9":  "vp9",
+	"libaom-av1":  "av1",
+	"libsvtav1":   "av1",
+	"libfdk_aac":  "aac",
+	"libopus":     "opus",
+	"libmp3lame":  "mp3",
+	"libvorbis":   "vorbis",
+}
+
+var (
+	placeholderPattern  = regexp.MustCompile(`\{[^}]*\}`)
+	outputPlaceholders  = []string{"{target}", "{name}"}
+	slaveFailurePattern = regexp.MustCompile(`Slave muxer #([0-9]+) failed: (.*), continuing with`)
+	teeEscaper          = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `[`, `\[`, `]`, `\]`)
+)
+
+type OutputStatus struct {
+	URL    string `json:"url"`
+	Status string `json:"status,omitempty"` // ok or failed while the stream is running
+	Error  string `json:"error,omitempty"`
+}
+
+// OutputURLs returns the URLs to publish the stream to. The first one is built from the
+// output path template, the stream's own target (or the default target) and the stream name,
+// and it's followed by the additional outputs that can use the same placeholders.
+func (entry *StreamEntry) OutputURLs(defaultTarget string) ([]string, error) {
+	target := entry.Target
+	if len(target) == 0 {
+		target = defaultTarget
+	}
+	if err := validateTarget(target); err != nil {
+		return nil, err
+	}
+	path := entry.OutputPath
+	if len(path) == 0 {
+		path = defaultOutputPath
+	}
+	var outputs []string
+	for i, path := range append([]string{path}, entry.Outputs...) {
+		output, err := resolveOutput(path, target, entry.Name)
+		if err != nil {
+			if i > 0 {
+				err = fmt.Errorf("output #%d: %w", i+1, err)
+			}
+			return nil, err
+		}
+		switch protocolOf(output) {
+		case srtProtocol:
+			output = entry.srtOutput(output)
+		case udpProtocol:
+			output = entry.udpOutput(output)
+		}
+		if contains(outputs, output) {
+			return nil, fmt.Errorf("duplicate output: %s", redactURL(output))
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+func resolveOutput(path, target, name string) (string, error) {
+	for _, placeholder := range placeholderPattern.FindAllString(path, -1) {
+		if !contains(outputPlaceholders, placeholder) {
+			return "", fmt.Errorf("unknown placeholder in output path: %s", placeholder)
+		}
+	}
+	output := strings.NewReplacer(
+		"{target}", strings.TrimSuffix(target, "/"),
+		"{name}", name,
+	).Replace(path)
+	if err := validateTarget(output); err != nil {
+		return "", fmt.Errorf("invalid output path: %w", err)
+	}
+	return output, nil
+}
+
+func (entry *StreamEntry) validateSRTOptions() error {
+	if len(entry.SRTMode) > 0 && !contains(srtModes, entry.SRTMode) {
+		return fmt.Errorf("invalid srt mode: %s", entry.SRTMode)
+	}
+	if entry.SRTLatency < 0 {
+		return fmt.Errorf("invalid srt latency: %v", entry.SRTLatency)
+	}
+	if n := len(entry.SRTPassphrase); n > 0 && (n < 10 || n > 79) {
+		return fmt.Errorf("srt passphrase must be 10 to 79 characters long")
+	}
+	switch entry.SRTKeyLength {
+	case 0:
+	case 16, 24, 32:
+		if len(entry.SRTPassphrase) == 0 {
+			return fmt.Errorf("srt key length needs a passphrase")
+		}
+	default:
+		return fmt.Errorf("invalid srt key length: %d", entry.SRTKeyLength)
+	}
+	return nil
+}
+
+// srtOutput adds the srt options of the stream to the URL (unless the URL already has them)
+func (entry *StreamEntry) srtOutput(output string) string {
+	return addQueryDefaults(output,
+		"mode", entry.SRTMode,
+		"latency", fmt.Sprint(entry.SRTLatency.Microseconds()),
+		"passphrase", entry.SRTPassphrase,
+		"pbkeylen", fmt.Sprint(entry.SRTKeyLength))
+}
+
+func (entry *StreamEntry) validateUDPOptions() error {
+	if entry.UDPPacketSize != 0 && (entry.UDPPacketSize < 188 || entry.UDPPacketSize > 65507) {
+		return fmt.Errorf("invalid udp packet size: %d", entry.UDPPacketSize)
+	}
+	if entry.UDPTTL < 0 || entry.UDPTTL > 255 {
+		return fmt.Errorf("invalid udp ttl: %d", entry.UDPTTL)
+	}
+	if len(entry.UDPLocalAddr) > 0 && net.ParseIP(entry.UDPLocalAddr) == nil {
+		return fmt.Errorf("invalid udp local address: %s", entry.UDPLocalAddr)
+	}
+	if entry.ServiceID < 0 || entry.ServiceID > 0xffff {
+		return fmt.Errorf("invalid service id: %d", entry.ServiceID)
+	}
+	if entry.PMTStartPID != 0 && (entry.PMTStartPID < 0x10 || entry.PMTStartPID > 0x1f00) {
+		return fmt.Errorf("invalid pmt start pid: %d", entry.PMTStartPID)
+	}
+	if entry.StartPID != 0 && (entry.StartPID < 0x20 || entry.StartPID > 0x0f00) {
+		return fmt.Errorf("invalid start pid: %d", entry.StartPID)
+	}
+	return nil
+}
+
+// udpOutput adds the udp options of the stream to the URL (unless the URL already has them)
+func (entry *StreamEntry) udpOutput(output string) string {
+	return addQueryDefaults(output,
+		"pkt_size", fmt.Sprint(entry.UDPPacketSize),
+		"ttl", fmt.Sprint(entry.UDPTTL),
+		"localaddr", entry.UDPLocalAddr)
+}
+
+// mpegtsOptions returns the mpegts muxer options of the stream as name, value pairs
+func (entry *StreamEntry) mpegtsOptions() (options []string) {
+	if entry.ServiceID > 0 {
+		options = append(options, "mpegts_service_id", fmt.Sprint(entry.ServiceID))
+	}
+	if entry.PMTStartPID > 0 {
+		options = append(options, "mpegts_pmt_start_pid", fmt.Sprint(entry.PMTStartPID))
+	}
+	if entry.StartPID > 0 {
+		options = append(options, "mpegts_start_pid", fmt.Sprint(entry.StartPID))
+	}
+	return
+}
+
+// addQueryDefaults sets the given key, value pairs in the query of the URL
+// unless they are empty (or zero) or the URL already has them
+func addQueryDefaults(rawURL string, params ...string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	for i := 0; i < len(params); i += 2 {
+		key, value := params[i], params[i+1]
+		if len(value) > 0 && value != "0" && !query.Has(key) {
+			query.Set(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func validateTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid target: %w", err)
+	}
+	if _, ok := outputProtocols[strings.ToLower(u.Scheme)]; !ok {
+		return fmt.Errorf("unsupported target protocol: %s", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return fmt.Errorf("no host in target: %s", target)
+	}
+	return nil
+}
+
+// redactURL hides the password and the srt passphrase in the URL (if there is any)
+func redactURL(rawURL string) string {
+	return replaceSecrets(rawURL, maskedSecret)
+}
+
+// stripSecrets removes the password and the srt passphrase from the URL
+func stripSecrets(rawURL string) string {
+	return replaceSecrets(rawURL, "")
+}
+
+// replaceSecrets replaces the password and the passphrase in the URL with the mask
+// (or removes them if the mask is empty). The URL is edited as text instead of being
+// parsed and encoded again, so the {target} and {name} placeholders are kept as they are.
+func replaceSecrets(rawURL, mask string) string {
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		authority := rawURL[i+3:]
+		if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+			authority = authority[:end]
+		}
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			if user, _, ok := strings.Cut(authority[:at], ":"); ok {
+				if len(mask) > 0 {
+					user += ":" + mask
+				}
+				rawURL = rawURL[:i+3] + user + rawURL[i+3+at:]
+			}
+		}
+	}
+	base, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		if strings.HasPrefix(param, "passphrase=") {
+			if len(mask) == 0 {
+				continue
+			}
+			param = "passphrase=" + mask
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		return base
+	}
+	return base + "?" + strings.Join(params, "&")
+}
+
+// mapURLs returns a copy of the entry with fn applied to every field that can hold a URL
+func (entry *StreamEntry) mapURLs(fn func(string) string) StreamEntry {
+	result := *entry
+	result.Source = fn(entry.Source)
+	result.SlateSource = fn(entry.SlateSource)
+	result.Target = fn(entry.Target)
+	result.OutputPath = fn(entry.OutputPath)
+	result.Outputs = nil
+	for _, output := range entry.Outputs {
+		result.Outputs = append(result.Outputs, fn(output))
+	}
+	result.Playlist = nil
+	for _, item := range entry.Playlist {
+		item.Source = fn(item.Source)
+		result.Playlist = append(result.Playlist, item)
+	}
+	return result
+}
+
+// Redacted returns a copy of the entry with its passwords and passphrases masked
+func (entry *StreamEntry) Redacted() StreamEntry {
+	result := entry.mapURLs(redactURL)
+	if len(result.SRTPassphrase) > 0 {
+		result.SRTPassphrase = maskedSecret
+	}
+	return result
+}
+
+// withoutSecrets returns a copy of the entry without its passwords and passphrases,
+// so they have to be entered again when the stream is cloned
+func (entry *StreamEntry) withoutSecrets() StreamEntry {
+	result := entry.mapURLs(stripSecrets)
+	result.SRTPassphrase = ""
+	return result
+}
+
+// secretRedactor masks the passwords and passphrases of the stream in the output of ffmpeg,
+// both in their raw and decoded form
+func (entry *StreamEntry) secretRedactor(outputs []string) *strings.Replacer {
+	urls := append([]string{entry.Source, entry.SlateSource}, outputs...)
+	for _, item := range entry.Playlist {
+		urls = append(urls, item.Source)
+	}
+	secrets := []string{entry.SRTPassphrase}
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if password, ok := u.User.Password(); ok {
+			_, escaped, _ := strings.Cut(u.User.String(), ":")
+			secrets = append(secrets, password, escaped)
+		}
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			if strings.HasPrefix(param, "passphrase=") {
+				value := strings.TrimPrefix(param, "passphrase=")
+				decoded, _ := url.QueryUnescape(value)
+				secrets = append(secrets, value, decoded)
+			}
+		}
+	}
+	// longer secrets first, so a secret containing another one gets masked entirely
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	var pairs []string
+	for _, secret := range secrets {
+		if len(secret) > 0 && !contains(pairs, secret) {
+			pairs = append(pairs, secret, maskedSecret)
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// outputArgs publishes to a single output directly, or to multiple outputs through
+// the tee muxer so a failing output doesn't stop the others
+func outputArgs(stream *Stream) (args []string) {
+	if len(stream.ServiceName) > 0 {
+		args = append(args, "-metadata", "service_name="+stream.ServiceName)
+	}
+	if len(stream.ServiceProvider) > 0 {
+		args = append(args, "-metadata", "service_provider="+stream.ServiceProvider)
+	}
+	if len(stream.outputURLs) == 1 {
+		output := stream.outputURLs[0]
+		protocol := protocolOf(output)
+		args = append(args, "-f", protocol.Format)
+		options := muxerOptions(stream, protocol)
+		for i := 0; i < len(options); i += 2 {
+			args = append(args, "-"+options[i], options[i+1])
+		}
+		return append(args, output)
+	}
+	slaves := make([]string, len(stream.outputURLs))
+	for i, output := range stream.outputURLs {
+		protocol := protocolOf(output)
+		opts := []string{"f=" + protocol.Format}
+		options := muxerOptions(stream, protocol)
+		for i := 0; i < len(options); i += 2 {
+			opts = append(opts, options[i]+"="+options[i+1])
+		}
+		opts = append(opts, "onfail=ignore")
+		slaves[i] = "[" + strings.Join(opts, ":") + "]" + teeEscaper.Replace(output)
+	}
+	// the encoders have to put the codec parameters in the header for muxers like flv
+	return append(args, "-flags", "+global_header", "-f", "tee", strings.Join(slaves, "|"))
+}
+
+func muxerOptions(stream *Stream, protocol *outputProtocol) []string {
+	if protocol == hlsProtocol {
+		return hlsOptions(stream)
+	}
+	if protocol.Format == "mpegts" {
+		return append(append([]string(nil), protocol.Options...), stream.mpegtsOptions()...)
+	}
+	return protocol.Options
+}
+
+// protocolOf returns the protocol of a validated output URL
+func protocolOf(output string) *outputProtocol {
+	scheme, _, ok := strings.Cut(output, "://")
+	if !ok && strings.HasSuffix(output, hlsPlaylist) {
+		return hlsProtocol
+	}
+	return outputProtocols[strings.ToLower(scheme)]
+}
+
+func (protocol *outputProtocol) Supports(codecType, codec string) bool {
+	switch codecType {
+	case "video":
+		return protocol.VideoCodecs == nil || contains(protocol.VideoCodecs, codec)
+	case "audio":
+		return protocol.AudioCodecs == nil || contains(protocol.AudioCodecs, codec)
+	default:
+		return true
+	}
+}
+
+// codecOf returns the codec produced by the given encoder
+func codecOf(encoder string) string {
+	if codec, ok := encoderCodecs[encoder]; ok {
+		return codec
+	}
+	codec, _, _ := strings.Cut(encoder, "_")
+	return codec
+}
+
+// checkCodec returns an error if any of the outputs can't carry the codec
+func checkCodec(outputs []string, codecType, codec string) error {
+	for _, output := range outputs {
+		if protocol := protocolOf(output); protocol != nil && !protocol.Supports(codecType, codec) {
+			scheme, _, _ := strings.Cut(output, "://")
+			return fmt.Errorf("%s codec %s is not supported by %s outputs", codecType, codec, scheme)
+		}
+	}
+	return nil
+}
+
+// checkProfile returns an error if the stream would encode to a codec that any of its outputs can't carry
+func checkProfile(entry *StreamEntry, outputs []string, profile *EncodingProfile) error {
+	if entry.Encoding == EncodingCopy {
+		return nil // it's up to the source
+	}
+	if entry.VideoChannel >= 0 {
+		if err := checkCodec(outputs, "video", codecOf(profile.VideoCodec)); err != nil {
+			return err
+		}
+	}
+	if entry.AudioChannel >= 0 {
+		if err := checkCodec(outputs, "audio", codecOf(profile.AudioCodec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputMonitor picks up the output failures reported by the tee muxer
+type outputMonitor struct {
+	mu     sync.Mutex
+	buf    []byte
+	failed map[int]string
+}
+
+func (om *outputMonitor) Write(p []byte) (int, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	om.buf = append(om.buf, p...)
+	for {
+		i := bytes.IndexByte(om.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if m := slaveFailurePattern.FindSubmatch(om.buf[:i]); m != nil {
+			if om.failed == nil {
+				om.failed = make(map[int]string)
+			}
+			index, _ := strconv.Atoi(string(m[1]))
+			om.failed[index] = string(m[2])
+		}
+		om.buf = om.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Failure returns the error of the output with the given index, if it failed
+func (om *outputMonitor) Failure(index int) (reason string, failed bool) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	reason, failed = om.failed[index]
+	return
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PlaylistItem struct {
+	Source          string        `json:"source"`
+	StartPosition   time.Duration `json:"startpos"`
+	VideoChannel    int           `json:"video"`
+	AudioChannel    int           `json:"audio"`
+	SubtitleChannel int           `json:"subtitle"`
+	Loops           int           `json:"loops,omitempty"` // -stream_loop value: -1 = forever
+}
+
+type EndPolicy string
+
+const (
+	EndStop  EndPolicy = "stop"
+	EndLoop  EndPolicy = "loop"
+	EndSlate EndPolicy = "slate"
+)
+
+func (policy EndPolicy) Validate(entry *StreamEntry) error {
+	switch policy {
+	case "", EndStop:
+		return nil
+	case EndLoop:
+		if entry.IsPlaylist() {
+			return fmt.Errorf("use repeat to loop playlists")
+		}
+		if entry.LoopCount < 0 {
+			return fmt.Errorf("invalid loop count: %d", entry.LoopCount)
+		}
+		return nil
+	case EndSlate:
+		if len(entry.SlateSource) == 0 {
+			return fmt.Errorf("no slate source")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid end of media policy: %s", policy)
+	}
+}
+
+type PlaylistStatus struct {
+	Item      int           `json:"item"`
+	Items     int           `json:"items"`
+	Source    string        `json:"source"`
+	Duration  time.Duration `json:"duration"`
+	Remaining time.Duration `json:"remaining"`
+}
+
+// ParsePlaylist parses one item per line in the following format:
+// source | start position | video stream # | audio stream # | subtitle #
+// Every field but the source is optional and falls back to the defaults.
+func ParsePlaylist(text string, defaults PlaylistItem) ([]PlaylistItem, error) {
+	var items []PlaylistItem
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		item := defaults
+		fields := strings.Split(line, "|")
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+		item.Source = fields[0]
+		var err error
+		if len(fields) > 1 && len(fields[1]) > 0 {
+			if item.StartPosition, err = time.ParseDuration(fields[1]); err != nil {
+				return nil, fmt.Errorf("playlist line %d: %v", i+1, err)
+			}
+		}
+		for j, channel := range []*int{&item.VideoChannel, &item.AudioChannel, &item.SubtitleChannel} {
+			if len(fields) > j+2 && len(fields[j+2]) > 0 {
+				if *channel, err = strconv.Atoi(fields[j+2]); err != nil {
+					return nil, fmt.Errorf("playlist line %d: %v", i+1, err)
+				}
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (entry *StreamEntry) PlaylistText() string {
+	var lines []string
+	for _, item := range entry.Playlist {
+		lines = append(lines, fmt.Sprintf("%s | %v | %d | %d | %d",
+			item.Source, item.StartPosition, item.VideoChannel, item.AudioChannel, item.SubtitleChannel))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SlateItem returns the fallback item to play once the media ended
+func (entry *StreamEntry) SlateItem() PlaylistItem {
+	item := PlaylistItem{
+		Source:          entry.SlateSource,
+		VideoChannel:    -1,
+		AudioChannel:    -1,
+		SubtitleChannel: -1,
+		Loops:           -1,
+	}
+	if entry.VideoChannel >= 0 {
+		item.VideoChannel = 0
+	}
+	if entry.AudioChannel >= 0 {
+		item.AudioChannel = 0
+	}
+	return item
+}
+
+func (entry *StreamEntry) IsPlaylist() bool {
+	return len(entry.Playlist) > 0
+}
+
+// playlist keeps track of the playback order and the current item of a stream.
+// Streams with a single source have a playlist of one item.
+type playlist struct {
+	items   []PlaylistItem
+	shuffle bool
+	repeat  bool
+	order   []int
+	index   int
+	rand    *rand.Rand
+}
+
+func newPlaylist(entry *StreamEntry) *playlist {
+	if !entry.IsPlaylist() {
+		item := PlaylistItem{
+			Source:          entry.Source,
+			StartPosition:   entry.StartPosition,
+			VideoChannel:    entry.VideoChannel,
+			AudioChannel:    entry.AudioChannel,
+			SubtitleChannel: entry.SubtitleChannel,
+		}
+		if entry.EndPolicy == EndLoop {
+			item.Loops = entry.LoopCount
+			if item.Loops == 0 {
+				item.Loops = -1
+			}
+		}
+		return &playlist{
+			items: []PlaylistItem{item},
+			order: []int{0},
+		}
+	}
+	p := &playlist{
+		items:   entry.Playlist,
+		shuffle: entry.Shuffle,
+		repeat:  entry.Repeat,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	p.rewind()
+	return p
+}
+
+func (p *playlist) rewind() {
+	if p.shuffle {
+		p.order = p.rand.Perm(len(p.items))
+	} else {
+		p.order = make([]int, len(p.items))
+		for i := range p.order {
+			p.order[i] = i
+		}
+	}
+	p.index = 0
+}
+
+func (p *playlist) Current() PlaylistItem {
+	return p.items[p.order[p.index]]
+}
+
+// Advance moves on to the next item and reports whether there is one
+func (p *playlist) Advance() bool {
+	if p.index+1 < len(p.order) {
+		p.index++
+		return true
+	}
+	if p.repeat {
+		p.rewind()
+		return true
+	}
+	return false
+}
+
+func (p *playlist) Cursor() (order []int, index int) {
+	return append([]int(nil), p.order...), p.index
+}
+
+// Restore sets the playback order and the current item if they are valid for this playlist
+func (p *playlist) Restore(order []int, index int) {
+	if len(order) != len(p.items) || index < 0 || index >= len(order) {
+		return
+	}
+	seen := make([]bool, len(p.items))
+	for _, i := range order {
+		if i < 0 || i >= len(p.items) || seen[i] {
+			return
+		}
+		seen[i] = true
+	}
+	p.order = append([]int(nil), order...)
+	p.index = index
+}
@@ -2,11 +2,75 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os/exec"
+	"strconv"
+	"time"
 )
 
+const probeTimeout = 30 * time.Second
+
 func Probe(ctx context.Context, source string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, "ffprobe",
 		"-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", source)
 	return cmd.Output()
 }
+
+type ProbeTrack struct {
+	Type        string `json:"codec_type"`
+	Codec       string `json:"codec_name"`
+	PixelFormat string `json:"pix_fmt"`
+}
+
+func ProbeTracks(ctx context.Context, source string) ([]ProbeTrack, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "quiet", "-print_format", "json", "-show_streams", source)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Streams []ProbeTrack `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, err
+	}
+	return result.Streams, nil
+}
+
+// findTrack returns the nth track of the given type (the same way ffmpeg's -map 0:v:n counts them)
+func findTrack(tracks []ProbeTrack, codecType string, n int) *ProbeTrack {
+	for i := range tracks {
+		if tracks[i].Type != codecType {
+			continue
+		}
+		if n == 0 {
+			return &tracks[i]
+		}
+		n--
+	}
+	return nil
+}
+
+func ProbeDuration(ctx context.Context, source string) (time.Duration, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "quiet", "-print_format", "json", "-show_format", source)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(result.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unknown duration")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
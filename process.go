@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// PIDRegistry keeps track of the running ffmpeg process groups in a file,
+// so they can be reaped if stream-manager crashes and leaves them behind
+type PIDRegistry struct {
+	mu   sync.Mutex
+	path string
+	pids map[int]string
+}
+
+func NewPIDRegistry(path string) *PIDRegistry {
+	return &PIDRegistry{
+		path: path,
+		pids: make(map[int]string),
+	}
+}
+
+func (reg *PIDRegistry) Add(pid int, name string) {
+	if reg == nil {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.pids[pid] = name
+	reg.save()
+}
+
+func (reg *PIDRegistry) Remove(pid int) {
+	if reg == nil {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.pids, pid)
+	reg.save()
+}
+
+func (reg *PIDRegistry) save() {
+	if len(reg.path) == 0 {
+		return
+	}
+	tmpPath := reg.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		log.Println("error while saving pid registry:", err)
+		return
+	}
+	w := bufio.NewWriter(file)
+	for pid, name := range reg.pids {
+		fmt.Fprintf(w, "%d %s\n", pid, name)
+	}
+	if err := w.Flush(); err != nil {
+		log.Println("error while saving pid registry:", err)
+	}
+	file.Close()
+	if err := os.Rename(tmpPath, reg.path); err != nil {
+		log.Println("error while saving pid registry:", err)
+	}
+}
+
+// ReapOrphans kills the ffmpeg process groups left behind by a previous instance
+func (reg *PIDRegistry) ReapOrphans() {
+	if reg == nil || len(reg.path) == 0 {
+		return
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	file, err := os.Open(reg.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("error while loading pid registry:", err)
+		}
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var pid int
+		var name string
+		if _, err := fmt.Sscan(scanner.Text(), &pid, &name); err != nil || pid <= 0 {
+			continue
+		}
+		if !isFFmpegGroupLeader(pid) || !isOrphaned(pid) {
+			continue
+		}
+		log.Printf("[%s] reaping orphaned ffmpeg process group %d", name, pid)
+		if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+			log.Printf("[%s] failed to reap process group %d: %v", name, pid, err)
+		}
+	}
+	reg.pids = make(map[int]string)
+	reg.save()
+}
+
+// isFFmpegGroupLeader makes sure a recorded pid wasn't reused by some unrelated process
+func isFFmpegGroupLeader(pid int) bool {
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid != pid {
+		return false
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	exe, _, _ := strings.Cut(string(cmdline), "\x00")
+	return filepath.Base(exe) == "ffmpeg"
+}
+
+// isOrphaned reports whether the parent of the process is gone, so it was reparented to init,
+// to the subreaper that started us or to us (if we are the init of a container).
+// This keeps the ffmpeg processes of another running instance alive.
+func isOrphaned(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// the command name in parentheses can contain spaces, so the fields are counted after it
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return false
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 2 {
+		return false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	return ppid == 1 || ppid == os.Getppid() || ppid == os.Getpid()
+}
+
+func signalGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
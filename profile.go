@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const DefaultProfile = "default"
+
+var (
+	codecOptionPattern = regexp.MustCompile("^[a-zA-Z0-9_-]*$")
+	bitratePattern     = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?[kKmM]?)?$`)
+)
+
+type EncodingProfile struct {
+	Name             string `json:"name"`
+	VideoCodec       string `json:"vcodec"`
+	Preset           string `json:"preset"`
+	Tune             string `json:"tune"`
+	CRF              int    `json:"crf"`
+	VideoBitrate     string `json:"vbitrate"`
+	MaxRate          string `json:"maxrate"`
+	BufSize          string `json:"bufsize"`
+	KeyframeInterval int    `json:"keyint"` // in frames
+	PixelFormat      string `json:"pixfmt"`
+	AudioCodec       string `json:"acodec"`
+	AudioBitrate     string `json:"abitrate"`
+	AudioChannels    int    `json:"channels"`
+	SampleRate       int    `json:"samplerate"`
+}
+
+func newDefaultProfile() *EncodingProfile {
+	return &EncodingProfile{
+		Name:       DefaultProfile,
+		VideoCodec: "libx264",
+		Preset:     "ultrafast",
+		AudioCodec: "aac",
+	}
+}
+
+func (p *EncodingProfile) Validate() error {
+	if !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid profile name: %s", p.Name)
+	}
+	if len(p.VideoCodec) == 0 || len(p.AudioCodec) == 0 {
+		return fmt.Errorf("no video or audio codec")
+	}
+	for _, opt := range []string{p.VideoCodec, p.Preset, p.Tune, p.PixelFormat, p.AudioCodec} {
+		if !codecOptionPattern.MatchString(opt) {
+			return fmt.Errorf("invalid codec option: %s", opt)
+		}
+	}
+	for _, bitrate := range []string{p.VideoBitrate, p.MaxRate, p.BufSize, p.AudioBitrate} {
+		if !bitratePattern.MatchString(bitrate) {
+			return fmt.Errorf("invalid bitrate: %s", bitrate)
+		}
+	}
+	if p.CRF < 0 || p.CRF > 63 {
+		return fmt.Errorf("invalid crf: %d", p.CRF)
+	}
+	if p.CRF > 0 && len(p.VideoBitrate) > 0 {
+		return fmt.Errorf("use either crf or target bitrate")
+	}
+	if p.KeyframeInterval < 0 {
+		return fmt.Errorf("invalid keyframe interval: %d", p.KeyframeInterval)
+	}
+	if p.AudioChannels < 0 || p.AudioChannels > 8 {
+		return fmt.Errorf("invalid audio channels: %d", p.AudioChannels)
+	}
+	if p.SampleRate < 0 {
+		return fmt.Errorf("invalid sample rate: %d", p.SampleRate)
+	}
+	return nil
+}
+
+func (p *EncodingProfile) videoArgs() []string {
+	args := []string{"-c:v", p.VideoCodec}
+	addOpt := func(opt, value string) {
+		if len(value) > 0 && value != "0" {
+			args = append(args, opt, value)
+		}
+	}
+	addOpt("-preset", p.Preset)
+	addOpt("-tune", p.Tune)
+	addOpt("-crf", fmt.Sprint(p.CRF))
+	addOpt("-b:v", p.VideoBitrate)
+	addOpt("-maxrate", p.MaxRate)
+	addOpt("-bufsize", p.BufSize)
+	addOpt("-g", fmt.Sprint(p.KeyframeInterval))
+	addOpt("-pix_fmt", p.PixelFormat)
+	return args
+}
+
+func (p *EncodingProfile) audioArgs() []string {
+	args := []string{"-c:a", p.AudioCodec}
+	addOpt := func(opt, value string) {
+		if len(value) > 0 && value != "0" {
+			args = append(args, opt, value)
+		}
+	}
+	addOpt("-b:a", p.AudioBitrate)
+	addOpt("-ac", fmt.Sprint(p.AudioChannels))
+	addOpt("-ar", fmt.Sprint(p.SampleRate))
+	return args
+}
+
+func (p *EncodingProfile) String() string {
+	args := append(p.videoArgs(), p.audioArgs()...)
+	return strings.Join(args, " ")
+}
+
+type EncodingMode string
+
+const (
+	EncodingEncode EncodingMode = "encode"
+	EncodingCopy   EncodingMode = "copy"
+	EncodingAuto   EncodingMode = "auto"
+)
+
+var (
+	copyVideoCodecs  = []string{"h264", "hevc"}
+	copyAudioCodecs  = []string{"aac", "opus"}
+	copyPixelFormats = []string{"", "yuv420p", "yuvj420p"}
+)
+
+func (mode EncodingMode) Validate() error {
+	switch mode {
+	case "", EncodingEncode, EncodingCopy, EncodingAuto:
+		return nil
+	default:
+		return fmt.Errorf("invalid encoding mode: %s", mode)
+	}
+}
+
+// canCopy reports whether the track can be sent to the target without re-encoding
+func canCopy(track *ProbeTrack) bool {
+	if track == nil {
+		return false
+	}
+	switch track.Type {
+	case "video":
+		return contains(copyVideoCodecs, track.Codec) && contains(copyPixelFormats, track.PixelFormat)
+	case "audio":
+		return contains(copyAudioCodecs, track.Codec)
+	default:
+		return false
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ProfileStore keeps the encoding profiles in memory and in db if there is one
+type ProfileStore struct {
+	mu       sync.RWMutex
+	profiles map[string]*EncodingProfile
+	db       *redis.Client
+}
+
+func profileKey(name string) string {
+	return "profile:" + name
+}
+
+func NewProfileStore(db *redis.Client) *ProfileStore {
+	store := &ProfileStore{
+		profiles: map[string]*EncodingProfile{DefaultProfile: newDefaultProfile()},
+		db:       db,
+	}
+	if db != nil {
+		store.load()
+	}
+	return store
+}
+
+func (store *ProfileStore) load() {
+	ctx := context.Background()
+	keys, err := store.db.Keys(ctx, profileKey("*")).Result()
+	if err != nil {
+		log.Println("db error:", err)
+		return
+	}
+	for _, key := range keys {
+		profileStr, err := store.db.Get(ctx, key).Result()
+		if err != nil {
+			log.Println("db error:", err)
+			continue
+		}
+		var profile EncodingProfile
+		if err := json.Unmarshal([]byte(profileStr), &profile); err != nil {
+			log.Println("json error:", err)
+			continue
+		}
+		if err := profile.Validate(); err != nil {
+			log.Printf("invalid profile %s: %v", key, err)
+			continue
+		}
+		store.profiles[profile.Name] = &profile
+	}
+}
+
+// Get returns a copy of the named profile (or the default profile if name is empty)
+func (store *ProfileStore) Get(name string) *EncodingProfile {
+	if len(name) == 0 {
+		name = DefaultProfile
+	}
+	if store == nil {
+		if name == DefaultProfile {
+			return newDefaultProfile()
+		}
+		return nil
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if profile, ok := store.profiles[name]; ok {
+		p := *profile
+		return &p
+	}
+	return nil
+}
+
+func (store *ProfileStore) List() []*EncodingProfile {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	results := make([]*EncodingProfile, 0, len(store.profiles))
+	for _, profile := range store.profiles {
+		p := *profile
+		results = append(results, &p)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+func (store *ProfileStore) Save(profile *EncodingProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	p := *profile
+	store.mu.Lock()
+	store.profiles[p.Name] = &p
+	store.mu.Unlock()
+	if store.db == nil {
+		return nil
+	}
+	profileStr, err := json.Marshal(&p)
+	if err != nil {
+		return err
+	}
+	if err := store.db.Set(context.Background(), profileKey(p.Name), string(profileStr), 0).Err(); err != nil {
+		log.Println("error while saving profile to db:", err)
+	}
+	return nil
+}
+
+func (store *ProfileStore) Delete(name string) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("the default profile cannot be deleted")
+	}
+	store.mu.Lock()
+	_, ok := store.profiles[name]
+	delete(store.profiles, name)
+	store.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	if store.db != nil {
+		if err := store.db.Del(context.Background(), profileKey(name)).Err(); err != nil {
+			log.Println("error while deleting profile from db:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Progress struct {
+	Frame      int64         `json:"frame"`
+	FPS        float64       `json:"fps"`
+	Bitrate    string        `json:"bitrate"`
+	OutTime    time.Duration `json:"out_time"`
+	Speed      float64       `json:"speed"`
+	DropFrames int64         `json:"drop_frames"`
+	DupFrames  int64         `json:"dup_frames"`
+	Updated    time.Time     `json:"updated"`
+}
+
+func (p *Progress) String() string {
+	return fmt.Sprintf("%v @ %.2fx, %.1f fps, %s, dropped:%d dup:%d",
+		p.OutTime.Round(time.Second), p.Speed, p.FPS, p.Bitrate, p.DropFrames, p.DupFrames)
+}
+
+// progressWriter parses the key=value blocks of ffmpeg's -progress output
+type progressWriter struct {
+	buf     []byte
+	current Progress
+	latest  atomic.Pointer[Progress]
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		pw.parseLine(string(pw.buf[:i]))
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (pw *progressWriter) parseLine(line string) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+	switch key {
+	case "frame":
+		pw.current.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		pw.current.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		pw.current.Bitrate = value
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			pw.current.OutTime = time.Duration(us) * time.Microsecond
+		}
+	case "speed":
+		pw.current.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "drop_frames":
+		pw.current.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "dup_frames":
+		pw.current.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "progress":
+		snapshot := pw.current
+		snapshot.Updated = time.Now()
+		pw.latest.Store(&snapshot)
+	}
+}
+
+func (pw *progressWriter) Latest() *Progress {
+	return pw.latest.Load()
+}
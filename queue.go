@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+type queuedStart struct {
+	stream   *Stream
+	startpos time.Duration
+}
+
+// startOrQueue starts the stream if there is a free slot (possibly by preempting
+// a lower priority stream), or puts it in the start queue otherwise
+func (sm *StreamManager) startOrQueue(stream *Stream, startpos time.Duration) error {
+	stream.probeSources()
+	sm.queueMu.Lock()
+	if sm.closing.Load() {
+		sm.queueMu.Unlock()
+		return ErrShuttingDown
+	}
+	if stream.IsActive() {
+		sm.queueMu.Unlock()
+		return fmt.Errorf("stream already started")
+	}
+	if stream.IsQueued() {
+		sm.queueMu.Unlock()
+		return nil
+	}
+	if sm.hasFreeSlot() {
+		defer sm.queueMu.Unlock()
+		return stream.StartAt(startpos)
+	}
+	victim := sm.preemptionVictim(stream.Priority)
+	if victim == nil {
+		defer sm.queueMu.Unlock()
+		if err := stream.enqueue(); err != nil {
+			return err
+		}
+		sm.enqueue(&queuedStart{stream: stream, startpos: startpos}, false)
+		log.Printf("[%s] queued at position %d", stream.Name, stream.QueuePosition())
+		return nil
+	}
+	// the victim's slot stays reserved while it shuts down outside the lock
+	sm.preempting[victim] = true
+	sm.queueMu.Unlock()
+
+	log.Printf("[%s] preempted by %s", victim.Name, stream.Name)
+	victim.logs.Printf("preempted by %s", stream.Name)
+	pos := victim.Position()
+	victim.CloseWithReason("preempted by " + stream.Name)
+
+	sm.queueMu.Lock()
+	defer sm.queueMu.Unlock()
+	delete(sm.preempting, victim)
+	if err := victim.enqueue(); err == nil {
+		sm.enqueue(&queuedStart{stream: victim, startpos: pos}, true)
+	}
+	if sm.closing.Load() {
+		return ErrShuttingDown
+	}
+	return stream.StartAt(startpos)
+}
+
+func (sm *StreamManager) dispatchQueue() {
+	sm.queueMu.Lock()
+	defer sm.queueMu.Unlock()
+	for len(sm.queue) > 0 && sm.hasFreeSlot() && !sm.closing.Load() {
+		next := sm.queue[0]
+		sm.queue = sm.queue[1:]
+		next.stream.queuePos.Store(0)
+		if _, ok := sm.streams.Load(next.stream.Name); !ok {
+			continue // deleted
+		}
+		if err := next.stream.StartAt(next.startpos); err != nil {
+			log.Printf("[%s] error while starting queued stream: %v", next.stream.Name, err)
+		}
+	}
+	sm.updateQueuePositions()
+}
+
+// dequeue removes the stream from the start queue and reports whether it was queued
+func (sm *StreamManager) dequeue(stream *Stream) bool {
+	sm.queueMu.Lock()
+	defer sm.queueMu.Unlock()
+	for i, q := range sm.queue {
+		if q.stream == stream {
+			sm.queue = append(sm.queue[:i], sm.queue[i+1:]...)
+			stream.dequeue()
+			sm.updateQueuePositions()
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue keeps the queue ordered by priority, and FIFO within the same priority.
+// Preempted streams go in front of the other streams with the same priority.
+func (sm *StreamManager) enqueue(q *queuedStart, front bool) {
+	i := 0
+	for ; i < len(sm.queue); i++ {
+		other := sm.queue[i].stream.Priority
+		if other < q.stream.Priority || (front && other == q.stream.Priority) {
+			break
+		}
+	}
+	sm.queue = append(sm.queue, nil)
+	copy(sm.queue[i+1:], sm.queue[i:])
+	sm.queue[i] = q
+	sm.updateQueuePositions()
+}
+
+func (sm *StreamManager) updateQueuePositions() {
+	for i, q := range sm.queue {
+		q.stream.queuePos.Store(int32(i + 1))
+	}
+}
+
+func (sm *StreamManager) hasFreeSlot() bool {
+	if sm.maxStreams <= 0 {
+		return true
+	}
+	active := len(sm.preempting)
+	sm.streams.Range(func(name string, stream *Stream) bool {
+		if stream.IsActive() {
+			active++
+		}
+		return true
+	})
+	return active < sm.maxStreams
+}
+
+func (sm *StreamManager) preemptionVictim(priority int) (victim *Stream) {
+	sm.streams.Range(func(name string, stream *Stream) bool {
+		if stream.IsActive() && stream.Priority < priority && !sm.preempting[stream] {
+			if victim == nil || stream.Priority < victim.Priority {
+				victim = stream
+			}
+		}
+		return true
+	})
+	return
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	restartBackoffMin = time.Second
+	restartBackoffMax = 5 * time.Minute
+	restartResetAfter = time.Minute
+)
+
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartAlways    RestartPolicy = "always"
+)
+
+func (policy RestartPolicy) Validate() error {
+	switch policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	default:
+		return fmt.Errorf("invalid restart policy: %s", policy)
+	}
+}
+
+func (policy RestartPolicy) ShouldRestart(exitErr error) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+func restartBackoff(attempt int) time.Duration {
+	delay := restartBackoffMin
+	for i := 1; i < attempt && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > restartBackoffMax {
+		delay = restartBackoffMax
+	}
+	return delay
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule returns the next activation time after the given time, or zero time if there is none
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// ParseSchedule parses a one-shot time (RFC 3339 or the format of html datetime-local inputs)
+// or a standard 5 field cron expression (minute, hour, day of month, month, day of week)
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return oneShotSchedule(t), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", spec, time.Local); err == nil {
+		return oneShotSchedule(t), nil
+	}
+	return parseCron(spec)
+}
+
+type oneShotSchedule time.Time
+
+func (s oneShotSchedule) Next(after time.Time) time.Time {
+	if t := time.Time(s); t.After(after) {
+		return t
+	}
+	return time.Time{}
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule: %q", spec)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 { // both 0 and 7 mean sunday
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField parses comma separated lists of values, ranges (a-b) and steps (*/n or a-b/n) into a bitset
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid cron step: %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid cron value: %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid cron value: %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("cron value out of range: %q", part)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+type ScheduledAction struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// streamSchedule keeps track of the next scheduled start and stop of a stream
+type streamSchedule struct {
+	mu        sync.Mutex
+	start     Schedule
+	stop      Schedule
+	nextStart time.Time
+	nextStop  time.Time
+}
+
+func newStreamSchedule(startSpec, stopSpec string) (*streamSchedule, error) {
+	if len(startSpec) == 0 && len(stopSpec) == 0 {
+		return nil, nil
+	}
+	s := &streamSchedule{}
+	var err error
+	if len(startSpec) > 0 {
+		if s.start, err = ParseSchedule(startSpec); err != nil {
+			return nil, err
+		}
+	}
+	if len(stopSpec) > 0 {
+		if s.stop, err = ParseSchedule(stopSpec); err != nil {
+			return nil, err
+		}
+	}
+	s.update(time.Now())
+	return s, nil
+}
+
+func (s *streamSchedule) update(now time.Time) {
+	if s.start != nil {
+		s.nextStart = s.start.Next(now)
+	}
+	if s.stop != nil {
+		s.nextStop = s.stop.Next(now)
+	}
+}
+
+// Due reports whether a scheduled start or stop is due and moves on to the next activation times
+func (s *streamSchedule) Due(now time.Time) (start, stop bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start = !s.nextStart.IsZero() && !now.Before(s.nextStart)
+	stop = !s.nextStop.IsZero() && !now.Before(s.nextStop)
+	if start || stop {
+		s.update(now)
+	}
+	return
+}
+
+func (s *streamSchedule) NextAction() *ScheduledAction {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.nextStart.IsZero() && (s.nextStop.IsZero() || s.nextStart.Before(s.nextStop)):
+		return &ScheduledAction{Action: "start", Time: s.nextStart}
+	case !s.nextStop.IsZero():
+		return &ScheduledAction{Action: "stop", Time: s.nextStop}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	// 2024-03-13 is a wednesday
+	tests := []struct {
+		spec  string
+		after string
+		want  string // empty if the schedule never matches
+	}{
+		{"0 20 * * 1-5", "2024-03-13 10:07", "2024-03-13 20:00"},
+		{"0 20 * * 1-5", "2024-03-15 20:00", "2024-03-18 20:00"},
+		{"*/15 * * * *", "2024-03-13 10:07", "2024-03-13 10:15"},
+		{"*/15 * * * *", "2024-03-13 10:45", "2024-03-13 11:00"},
+		{"5-10/2 * * * *", "2024-03-13 10:07", "2024-03-13 10:09"},
+		{"5-10/2 * * * *", "2024-03-13 10:09", "2024-03-13 11:05"},
+		{"0 9 1,15 * *", "2024-03-13 10:07", "2024-03-15 09:00"},
+		{"30 6 * 12 *", "2024-03-13 10:07", "2024-12-01 06:30"},
+		// day of month or day of week if both are restricted
+		{"*/15 * 13 * 5", "2024-03-13 10:07", "2024-03-13 10:15"},
+		{"*/15 * 13 * 5", "2024-03-13 23:50", "2024-03-15 00:00"},
+		{"0 0 13 * *", "2024-03-13 10:07", "2024-04-13 00:00"},
+		{"0 0 * * 5", "2024-03-13 10:07", "2024-03-15 00:00"},
+		// both 0 and 7 mean sunday
+		{"0 12 * * 0", "2024-03-13 10:07", "2024-03-17 12:00"},
+		{"0 12 * * 7", "2024-03-13 10:07", "2024-03-17 12:00"},
+		{"@hourly", "2024-03-13 10:07", "2024-03-13 11:00"},
+		{"@daily", "2024-03-13 10:07", "2024-03-14 00:00"},
+		{"@weekly", "2024-03-13 10:07", "2024-03-17 00:00"},
+		{"@monthly", "2024-03-13 10:07", "2024-04-01 00:00"},
+		{"0 0 29 2 *", "2024-03-13 10:07", "2028-02-29 00:00"},
+		{"0 0 30 2 *", "2024-03-13 10:07", ""},
+		{"0 0 31 4,6,9,11 *", "2024-03-13 10:07", ""},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.spec, err)
+			continue
+		}
+		got := s.Next(at(tt.after))
+		var want time.Time
+		if len(tt.want) > 0 {
+			want = at(tt.want)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q after %s: got %v, want %v", tt.spec, tt.after, got, want)
+		}
+	}
+}
+
+func TestCronScheduleNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	// clocks jump from 02:00 to 03:00 on 2024-03-31
+	after := time.Date(2024, 3, 30, 12, 0, 0, 0, loc)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2024, 3, 31, 3, 0, 0, 0, loc)},
+		{"30 2 * * *", time.Date(2024, 4, 1, 2, 30, 0, 0, loc)}, // skipped on the day of the change
+		{"0 * 31 3 *", time.Date(2024, 3, 31, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		if got := s.Next(after); !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@yearly",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) should fail", spec)
+		}
+	}
+}
+
+func TestParseScheduleOneShot(t *testing.T) {
+	s, err := ParseSchedule("2024-03-13T20:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 3, 13, 20, 0, 0, 0, time.UTC)
+	if got := s.Next(want.Add(-time.Hour)); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := s.Next(want); !got.IsZero() {
+		t.Errorf("got %v after the one-shot time, want zero", got)
+	}
+}
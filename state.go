@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type StreamState string
+
+const (
+	StateIdle       StreamState = "idle"
+	StateQueued     StreamState = "queued"
+	StateStarting   StreamState = "starting"
+	StateRunning    StreamState = "running"
+	StateRestarting StreamState = "restarting"
+	StateStopping   StreamState = "stopping"
+	StateCompleted  StreamState = "completed"
+	StateFailed     StreamState = "failed"
+)
+
+var stateTransitions = map[StreamState][]StreamState{
+	StateIdle:       {StateQueued, StateStarting},
+	StateQueued:     {StateIdle, StateStarting},
+	StateStarting:   {StateRunning, StateRestarting, StateFailed, StateStopping},
+	StateRunning:    {StateStarting, StateCompleted, StateRestarting, StateFailed, StateStopping},
+	StateRestarting: {StateStarting, StateStopping},
+	StateStopping:   {StateIdle},
+	StateCompleted:  {StateIdle, StateQueued, StateStarting},
+	StateFailed:     {StateIdle, StateQueued, StateStarting},
+}
+
+// IsActive reports whether a stream in this state occupies a slot
+func (state StreamState) IsActive() bool {
+	switch state {
+	case StateStarting, StateRunning, StateRestarting:
+		return true
+	default:
+		return false
+	}
+}
+
+func (state StreamState) CanTransitionTo(to StreamState) bool {
+	for _, s := range stateTransitions[state] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+type stateMachine struct {
+	mu      sync.Mutex
+	state   StreamState
+	entered map[StreamState]time.Time
+}
+
+func newStateMachine() *stateMachine {
+	return &stateMachine{
+		state:   StateIdle,
+		entered: map[StreamState]time.Time{StateIdle: time.Now()},
+	}
+}
+
+func (fsm *stateMachine) Transition(to StreamState) (from StreamState, err error) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	from = fsm.state
+	if !from.CanTransitionTo(to) {
+		return from, fmt.Errorf("invalid state transition: %s -> %s", from, to)
+	}
+	fsm.state = to
+	fsm.entered[to] = time.Now()
+	return from, nil
+}
+
+// State returns the current state and the time it was entered
+func (fsm *stateMachine) State() (StreamState, time.Time) {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	return fsm.state, fsm.entered[fsm.state]
+}
+
+// Timestamps returns the time each state was last entered
+func (fsm *stateMachine) Timestamps() map[StreamState]time.Time {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+	results := make(map[StreamState]time.Time, len(fsm.entered))
+	for state, t := range fsm.entered {
+		results[state] = t
+	}
+	return results
+}
@@ -3,170 +3,840 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"log"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var namePattern = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
 
+var (
+	errRunnerClosed = fmt.Errorf("runner closed before start")
+	errStalled      = fmt.Errorf("stalled")
+)
+
+var liveSchemes = []string{"rtsp", "rtsps", "rtmp", "rtmps", "srt", "udp", "rtp", "tcp"}
+
+var logLevels = []string{"quiet", "panic", "fatal", "error", "warning", "info", "verbose", "debug"}
+
 type Stream struct {
-	Name            string
-	Source          string
-	Target          string
-	StartPosition   time.Duration
-	VideoChannel    int
-	AudioChannel    int
-	SubtitleChannel int
-	ReadRate        int
-	runner          atomic.Pointer[StreamRunner]
-}
-
-func NewStream(name, source, target string, startpos time.Duration, video, audio, subtitle, readrate int) (*Stream, error) {
-	if !namePattern.MatchString(name) {
-		return nil, fmt.Errorf("invalid name: %s", name)
-	}
-	if len(source) == 0 {
+	StreamEntry
+	outputURLs  []string
+	GracePeriod time.Duration
+	mu          sync.Mutex // serializes lifecycle changes
+	state       *stateMachine
+	runner      atomic.Pointer[StreamRunner]
+	resumePos   atomic.Int64 // position to resume from when there is no runner to tell
+	restarts    atomic.Int32
+	nextRetry   atomic.Pointer[time.Time]
+	retryTimer  *time.Timer
+	logs        *LogBuffer
+	redactor    *strings.Replacer // masks the secrets in the output of ffmpeg
+	pids        *PIDRegistry
+	profiles    *ProfileStore
+	hlsDir      string
+	probes      sync.Map // source -> []ProbeTrack
+	queuePos    atomic.Int32
+	playlist    *playlist
+	schedule    *streamSchedule
+	onIdle      func(*Stream)
+	onEvent     func(*Stream, *StreamEvent)
+}
+
+func NewStream(entry StreamEntry, target, hlsRoot string, grace time.Duration, pids *PIDRegistry, profiles *ProfileStore) (*Stream, error) {
+	if !namePattern.MatchString(entry.Name) {
+		return nil, fmt.Errorf("invalid name: %s", entry.Name)
+	}
+	if len(entry.Source) == 0 && !entry.IsPlaylist() {
 		return nil, fmt.Errorf("no source")
 	}
-	if len(target) == 0 {
-		return nil, fmt.Errorf("no target")
+	if err := entry.EndPolicy.Validate(&entry); err != nil {
+		return nil, err
+	}
+	for i, item := range entry.Playlist {
+		if len(item.Source) == 0 {
+			return nil, fmt.Errorf("no source for playlist item #%d", i+1)
+		}
+	}
+	if err := entry.HLS.Validate(&entry); err != nil {
+		return nil, err
+	}
+	var outputs []string
+	if entry.HLS != HLSOnly {
+		var err error
+		if outputs, err = entry.OutputURLs(target); err != nil {
+			return nil, err
+		}
+	}
+	var hlsDir string
+	if entry.HLS.Enabled() {
+		hlsDir = filepath.Join(hlsRoot, entry.Name)
+		outputs = append(outputs, filepath.Join(hlsDir, hlsPlaylist))
+	}
+	if err := entry.RestartPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	if entry.StallTimeout < 0 {
+		return nil, fmt.Errorf("invalid stall timeout: %v", entry.StallTimeout)
+	}
+	if entry.MaxRetries < 0 {
+		return nil, fmt.Errorf("invalid max retries: %d", entry.MaxRetries)
+	}
+	if err := entry.Encoding.Validate(); err != nil {
+		return nil, err
+	}
+	if err := entry.validateVideoFilters(); err != nil {
+		return nil, err
+	}
+	if err := entry.validateSRTOptions(); err != nil {
+		return nil, err
+	}
+	if err := entry.validateUDPOptions(); err != nil {
+		return nil, err
+	}
+	profile := profiles.Get(entry.Profile)
+	if profile == nil {
+		return nil, fmt.Errorf("unknown profile: %s", entry.Profile)
+	}
+	if len(entry.LogLevel) > 0 && !isLogLevel(entry.LogLevel) {
+		return nil, fmt.Errorf("invalid log level: %s", entry.LogLevel)
+	}
+	if err := checkProfile(&entry, outputs, profile); err != nil {
+		return nil, err
+	}
+	schedule, err := newStreamSchedule(entry.StartSchedule, entry.StopSchedule)
+	if err != nil {
+		return nil, err
 	}
+	redactor := entry.secretRedactor(outputs)
 	stream := &Stream{
-		Name:            name,
-		Source:          source,
-		Target:          target,
-		StartPosition:   startpos,
-		VideoChannel:    video,
-		AudioChannel:    audio,
-		SubtitleChannel: subtitle,
-		ReadRate:        readrate,
+		StreamEntry: entry,
+		outputURLs:  outputs,
+		GracePeriod: grace,
+		state:       newStateMachine(),
+		logs:        NewLogBuffer(logBufferSize, redactor),
+		redactor:    redactor,
+		pids:        pids,
+		profiles:    profiles,
+		hlsDir:      hlsDir,
+		playlist:    newPlaylist(&entry),
+		schedule:    schedule,
 	}
+	stream.resumePos.Store(int64(entry.StartPosition))
 	return stream, nil
 }
 
-func (stream *Stream) Start() error {
-	runner := NewStreamRunner(stream)
-	if !stream.runner.CompareAndSwap(nil, runner) {
-		for {
-			old := stream.runner.Load()
-			if old.IsRunning() {
-				return fmt.Errorf("stream already started")
+func (stream *Stream) setState(to StreamState) bool {
+	from, err := stream.state.Transition(to)
+	if err != nil {
+		log.Printf("[%s] %v", stream.Name, err)
+		return false
+	}
+	if from.IsActive() && !to.IsActive() && stream.onIdle != nil {
+		go stream.onIdle(stream)
+	}
+	return true
+}
+
+func (stream *Stream) emit(event *StreamEvent) {
+	event.Time = time.Now()
+	if stream.onEvent != nil {
+		stream.onEvent(stream, event)
+	}
+}
+
+// startFromBeginning makes StartAt rewind the playlist and use the start position of the first item
+const startFromBeginning time.Duration = -1
+
+// StartAt starts playing the current playlist item from the given position
+func (stream *Stream) StartAt(startpos time.Duration) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if state := stream.State(); !state.CanTransitionTo(StateStarting) {
+		return fmt.Errorf("stream already %s", state)
+	}
+	stream.cancelRetry()
+	stream.restarts.Store(0)
+	if err := stream.resetHLSDir(); err != nil {
+		return err
+	}
+	if startpos == startFromBeginning {
+		stream.playlist.rewind()
+		startpos = stream.playlist.Current().StartPosition
+	}
+	return stream.run(stream.newRunner(startpos), EventStarted)
+}
+
+// newRunner must be called with stream.mu held
+func (stream *Stream) newRunner(startpos time.Duration) *StreamRunner {
+	_, index := stream.playlist.Cursor()
+	return NewStreamRunner(stream, stream.playlist.Current(), index+1, startpos)
+}
+
+// run must be called with stream.mu held
+func (stream *Stream) run(runner *StreamRunner, eventType EventType) error {
+	stream.setState(StateStarting)
+	stream.runner.Store(runner)
+	err := runner.Start()
+	if err == nil {
+		stream.setState(StateRunning)
+		event := &StreamEvent{Type: eventType}
+		var details []string
+		if runner.IsSlate() {
+			details = append(details, fmt.Sprintf("slate (%s)", runner.item.Source))
+		} else if stream.IsPlaylist() {
+			details = append(details, fmt.Sprintf("item %d/%d (%s)", runner.itemNo, len(stream.Playlist), runner.item.Source))
+		}
+		if runner.startPos > 0 {
+			details = append(details, fmt.Sprintf("at %v", runner.startPos))
+		}
+		event.Message = strings.Join(details, " ")
+		stream.emit(event)
+	} else {
+		stream.emit(&StreamEvent{Type: EventError, Message: err.Error()})
+	}
+	go stream.supervise(runner)
+	return err
+}
+
+func (stream *Stream) supervise(runner *StreamRunner) {
+	<-runner.Done()
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if state := stream.State(); stream.runner.Load() != runner ||
+		(state != StateStarting && state != StateRunning) {
+		return // stopped or replaced
+	}
+	exitErr := runner.ExitErr()
+	if code := exitCode(exitErr); code != nil {
+		event := &StreamEvent{Type: EventExited, ExitCode: code, Uptime: runner.Uptime()}
+		if err := runner.Err(); err != nil {
+			event.Message = err.Error()
+		}
+		stream.emit(event)
+	}
+	if exitErr == nil && !runner.IsSlate() {
+		var next *StreamRunner
+		if stream.playlist.Advance() {
+			next = stream.newRunner(stream.playlist.Current().StartPosition)
+		} else if stream.EndPolicy == EndSlate {
+			stream.resumePos.Store(int64(runner.Position()))
+			next = NewStreamRunner(stream, stream.SlateItem(), 0, 0)
+		}
+		if next != nil {
+			if err := stream.run(next, EventStarted); err != nil {
+				log.Printf("[%s] error while starting %s: %v", stream.Name, next.item.Source, err)
+			}
+			return
+		}
+	}
+	if stream.RestartPolicy.ShouldRestart(exitErr) {
+		if runner.Uptime() >= restartResetAfter {
+			stream.restarts.Store(0)
+		}
+		if stream.MaxRetries == 0 || int(stream.restarts.Load()) < stream.MaxRetries {
+			stream.scheduleRestart(runner)
+			return
+		}
+		log.Printf("[%s] giving up after %d restarts", stream.Name, stream.MaxRetries)
+	}
+	if exitErr != nil {
+		stream.setState(StateFailed)
+	} else {
+		stream.setState(StateCompleted)
+	}
+	stream.removeHLSDir()
+}
+
+// scheduleRestart must be called with stream.mu held
+func (stream *Stream) scheduleRestart(runner *StreamRunner) {
+	attempt := int(stream.restarts.Add(1))
+	delay := restartBackoff(attempt)
+	next := time.Now().Add(delay)
+	stream.nextRetry.Store(&next)
+	stream.retryTimer = time.AfterFunc(delay, func() {
+		stream.restart(runner)
+	})
+	stream.setState(StateRestarting)
+	log.Printf("[%s] restarting in %v (attempt %d)", stream.Name, delay, attempt)
+	stream.logs.Printf("restarting in %v (attempt %d)", delay, attempt)
+}
+
+func (stream *Stream) restart(old *StreamRunner) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.runner.Load() != old || stream.State() != StateRestarting {
+		return
+	}
+	stream.retryTimer = nil
+	stream.nextRetry.Store(nil)
+	if err := stream.run(NewStreamRunner(stream, old.item, old.itemNo, old.startPos), EventRestarted); err != nil {
+		log.Printf("[%s] restart failed: %v", stream.Name, err)
+	}
+}
+
+// cancelRetry must be called with stream.mu held
+func (stream *Stream) cancelRetry() {
+	if stream.retryTimer != nil {
+		stream.retryTimer.Stop()
+		stream.retryTimer = nil
+	}
+	stream.nextRetry.Store(nil)
+}
+
+func (stream *Stream) Restarts() int {
+	return int(stream.restarts.Load())
+}
+
+func (stream *Stream) NextRetry() time.Time {
+	if next := stream.nextRetry.Load(); next != nil {
+		return *next
+	}
+	return time.Time{}
+}
+
+func (stream *Stream) State() StreamState {
+	state, _ := stream.state.State()
+	return state
+}
+
+func (stream *Stream) StateSince() time.Time {
+	_, since := stream.state.State()
+	return since
+}
+
+func (stream *Stream) StateTimes() map[StreamState]time.Time {
+	return stream.state.Timestamps()
+}
+
+// StateDetails returns human readable details about the current state
+func (stream *Stream) StateDetails() string {
+	switch stream.State() {
+	case StateQueued:
+		return fmt.Sprintf("position %d", stream.QueuePosition())
+	case StateRunning:
+		if runner := stream.runner.Load(); runner != nil {
+			if runner.IsStalled() {
+				return "stalled"
+			} else if runner.IsSlate() {
+				return "slate"
+			}
+		}
+	case StateRestarting:
+		return fmt.Sprintf("attempt %d in %v", stream.Restarts(), time.Until(stream.NextRetry()).Round(time.Second))
+	case StateFailed:
+		if runner := stream.runner.Load(); runner != nil {
+			if err := runner.Err(); err != nil {
+				return err.Error()
 			}
-			if stream.runner.CompareAndSwap(old, runner) {
-				break
+		}
+	}
+	return ""
+}
+
+// copyTracks decides whether the video and audio tracks of the item can be passed through.
+// Video filters (like burned-in subtitles) always need the video to be re-encoded.
+func (stream *Stream) copyTracks(item *PlaylistItem) (video, audio bool) {
+	filtered := len(videoFilters(&stream.StreamEntry, item)) > 0
+	switch stream.Encoding {
+	case EncodingCopy:
+		return !filtered, true
+	case EncodingAuto:
+		probed, ok := stream.probes.Load(item.Source)
+		if !ok {
+			stream.logs.Printf("no probe result for %s, re-encoding", item.Source)
+			return false, false
+		}
+		tracks := probed.([]ProbeTrack)
+		video = !filtered && stream.canCopy(findTrack(tracks, "video", item.VideoChannel))
+		audio = stream.canCopy(findTrack(tracks, "audio", item.AudioChannel))
+		stream.logs.Printf("auto encoding: copy video: %v, copy audio: %v", video, audio)
+		return
+	default:
+		return false, false
+	}
+}
+
+func (stream *Stream) canCopy(track *ProbeTrack) bool {
+	return canCopy(track) && checkCodec(stream.outputURLs, track.Type, track.Codec) == nil
+}
+
+// probeSources probes the sources of an auto encoding stream that aren't probed yet.
+// It must be called without holding any locks, so copyTracks only has to look up the results.
+func (stream *Stream) probeSources() {
+	if stream.Encoding != EncodingAuto {
+		return
+	}
+	sources := []string{stream.Source, stream.SlateSource}
+	for _, item := range stream.Playlist {
+		sources = append(sources, item.Source)
+	}
+	var wg sync.WaitGroup
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		if _, ok := stream.probes.Load(source); ok || len(source) == 0 || seen[source] {
+			continue
+		}
+		seen[source] = true
+		wg.Add(1)
+		go func(source string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+			defer cancel()
+			tracks, err := ProbeTracks(ctx, source)
+			if err != nil {
+				stream.logs.Printf("failed to probe %s: %v", source, err)
+				return
 			}
+			stream.probes.Store(source, tracks)
+		}(source)
+	}
+	wg.Wait()
+}
+
+// IsActive reports whether the stream is running or about to be (re)started
+func (stream *Stream) IsActive() bool {
+	return stream.State().IsActive()
+}
+
+// Position returns the current playback position in the source,
+// or the position where playback stopped if the stream is not running
+func (stream *Stream) Position() time.Duration {
+	runner := stream.runner.Load()
+	if runner == nil || runner.IsSlate() {
+		return time.Duration(stream.resumePos.Load())
+	}
+	if isLiveSource(runner.item.Source) {
+		return runner.item.StartPosition
+	}
+	return runner.Position()
+}
+
+// RestorePosition sets the position to resume an idle stream from
+func (stream *Stream) RestorePosition(pos time.Duration) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.runner.Load() == nil {
+		stream.resumePos.Store(int64(pos))
+	}
+}
+
+// PlaylistCursor returns the playback order and the index of the current playlist item
+func (stream *Stream) PlaylistCursor() (order []int, index int) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return stream.playlist.Cursor()
+}
+
+// RestorePlaylistCursor sets the playback order and the current item of an idle stream
+func (stream *Stream) RestorePlaylistCursor(order []int, index int) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	stream.playlist.Restore(order, index)
+}
+
+func (stream *Stream) PlaylistStatus() *PlaylistStatus {
+	runner := stream.runner.Load()
+	if !stream.IsPlaylist() || runner == nil || !runner.IsRunning() {
+		return nil
+	}
+	status := &PlaylistStatus{
+		Item:     runner.itemNo,
+		Items:    len(stream.Playlist),
+		Source:   runner.item.Source,
+		Duration: runner.Duration(),
+	}
+	if status.Duration > 0 {
+		status.Remaining = status.Duration - runner.Position()
+		if status.Remaining < 0 {
+			status.Remaining = 0
 		}
 	}
-	return runner.Start()
+	return status
 }
 
-func (stream *Stream) Status() string {
-	if runner := stream.runner.Load(); runner != nil {
-		if runner.IsRunning() {
-			return "Running"
-		} else if err := runner.Err(); err != nil {
-			return "Error: " + err.Error()
+func (stream *Stream) OutputStatus() []OutputStatus {
+	runner := stream.runner.Load()
+	results := make([]OutputStatus, len(stream.outputURLs))
+	for i, output := range stream.outputURLs {
+		results[i].URL = redactURL(output)
+		if protocolOf(output) == hlsProtocol {
+			results[i].URL = hlsURL(stream.Name)
+		}
+		if runner == nil || !runner.IsRunning() {
+			continue
 		}
+		if reason, failed := runner.outputs.Failure(i); failed {
+			results[i].Status = "failed"
+			results[i].Error = stream.redactor.Replace(reason)
+		} else {
+			results[i].Status = "ok"
+		}
+	}
+	return results
+}
+
+func (stream *Stream) Logs() []LogLine {
+	return stream.logs.Lines()
+}
+
+func (stream *Stream) Progress() *Progress {
+	if runner := stream.runner.Load(); runner != nil && runner.IsRunning() {
+		return runner.Progress()
+	}
+	return nil
+}
+
+func (stream *Stream) IsQueued() bool {
+	return stream.State() == StateQueued
+}
+
+func (stream *Stream) QueuePosition() int {
+	return int(stream.queuePos.Load())
+}
+
+func (stream *Stream) enqueue() error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if state := stream.State(); !stream.setState(StateQueued) {
+		return fmt.Errorf("stream already %s", state)
+	}
+	return nil
+}
+
+func (stream *Stream) dequeue() {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	stream.queuePos.Store(0)
+	if stream.State() == StateQueued {
+		stream.setState(StateIdle)
 	}
-	return "Stopped"
 }
 
 func (stream *Stream) Close() error {
-	if runner := stream.runner.Swap(nil); runner != nil {
-		runner.Close() // ignore exit status 1 error
+	return stream.CloseWithReason("by user")
+}
+
+func (stream *Stream) CloseWithReason(reason string) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	stream.cancelRetry()
+	switch stream.State() {
+	case StateStarting, StateRunning, StateRestarting:
+		stream.setState(StateStopping)
+		event := &StreamEvent{Type: EventStopped, Message: reason}
+		if runner := stream.runner.Load(); runner != nil {
+			if runner.IsRunning() {
+				event.Uptime = runner.Uptime()
+			}
+			if err := runner.Close(stream.GracePeriod); err != nil {
+				log.Printf("[%s] ffmpeg did not quit cleanly: %v", stream.Name, err)
+			}
+		}
+		stream.emit(event)
+		stream.setState(StateIdle)
+	case StateCompleted, StateFailed:
+		stream.setState(StateIdle)
 	}
+	stream.removeHLSDir()
 	return nil
 }
 
 type StreamRunner struct {
-	Stream  *Stream
-	cmd     *exec.Cmd
-	ctx     context.Context
-	cancel  context.CancelFunc
-	errChan chan error
-	errBuf  strings.Builder
-	done    atomic.Bool
-}
-
-func NewStreamRunner(stream *Stream) *StreamRunner {
-	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs(stream)...)
+	Stream   *Stream
+	cmd      *exec.Cmd
+	mu       sync.Mutex
+	closing  bool
+	stopped  bool
+	stalled  atomic.Bool
+	item     PlaylistItem
+	itemNo   int // position in the playlist starting from 1, or 0 for the slate
+	startPos time.Duration
+	duration atomic.Int64
+	logStart int
+	progress progressWriter
+	outputs  outputMonitor
+	started  time.Time
+	exited   chan struct{}
+	exitErr  error
+}
+
+func NewStreamRunner(stream *Stream, item PlaylistItem, itemNo int, startpos time.Duration) *StreamRunner {
+	cmd := exec.Command("ffmpeg", ffmpegArgs(stream, &item, startpos)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	return &StreamRunner{
-		Stream:  stream,
-		cmd:     cmd,
-		ctx:     ctx,
-		cancel:  cancel,
-		errChan: make(chan error, 1),
+		Stream:   stream,
+		cmd:      cmd,
+		item:     item,
+		itemNo:   itemNo,
+		startPos: startpos,
+		exited:   make(chan struct{}),
 	}
 }
 
 func (runner *StreamRunner) Start() error {
-	runner.cmd.Stdout = &runner.errBuf
-	runner.cmd.Stderr = &runner.errBuf
+	runner.cmd.Stdout = &runner.progress
+	runner.cmd.Stderr = runner.Stream.logs
+	if len(runner.Stream.outputURLs) > 1 {
+		runner.cmd.Stderr = io.MultiWriter(runner.Stream.logs, &runner.outputs)
+	}
+	runner.logStart = runner.Stream.logs.Total()
+	runner.started = time.Now()
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	if runner.closing {
+		runner.exitErr = errRunnerClosed
+		close(runner.exited)
+		return runner.exitErr
+	}
 	if err := runner.cmd.Start(); err != nil {
+		runner.exitErr = err
+		close(runner.exited)
 		return err
 	}
+	pid := runner.cmd.Process.Pid
+	runner.Stream.pids.Add(pid, runner.Stream.Name)
+	if runner.Stream.IsPlaylist() {
+		go runner.probeDuration()
+	}
+	if timeout := runner.Stream.StallTimeout; timeout > 0 {
+		go runner.watchdog(pid, timeout)
+	}
 	go func() {
-		runner.errChan <- runner.cmd.Wait()
-		runner.done.Store(true)
+		runner.exitErr = runner.cmd.Wait()
+		signalGroup(pid, syscall.SIGKILL) // don't leave any helper processes behind
+		runner.Stream.pids.Remove(pid)
+		close(runner.exited)
 	}()
 	return nil
 }
 
+// watchdog marks the runner as stalled if ffmpeg doesn't make progress for the given timeout,
+// and kills it if the restart policy would restart it
+func (runner *StreamRunner) watchdog(pid int, timeout time.Duration) {
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastFrame int64
+	var lastOutTime time.Duration
+	lastChange := time.Now()
+	for {
+		select {
+		case <-runner.exited:
+			return
+		case <-ticker.C:
+		}
+		if progress := runner.Progress(); progress != nil &&
+			(progress.Frame != lastFrame || progress.OutTime != lastOutTime) {
+			lastFrame, lastOutTime = progress.Frame, progress.OutTime
+			lastChange = time.Now()
+			runner.stalled.Store(false)
+			continue
+		}
+		if time.Since(lastChange) < timeout || runner.stalled.Load() {
+			continue
+		}
+		runner.stalled.Store(true)
+		log.Printf("[%s] stalled: no progress for %v", runner.Stream.Name, timeout)
+		runner.Stream.logs.Printf("stalled: no progress for %v", timeout)
+		runner.Stream.emit(&StreamEvent{Type: EventError, Message: fmt.Sprintf("stalled: no progress for %v", timeout)})
+		if runner.Stream.RestartPolicy.ShouldRestart(errStalled) {
+			signalGroup(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+func (runner *StreamRunner) probeDuration() {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	duration, err := ProbeDuration(ctx, runner.item.Source)
+	if err != nil {
+		log.Printf("[%s] failed to probe duration of %s: %v", runner.Stream.Name, runner.item.Source, err)
+		return
+	}
+	runner.duration.Store(int64(duration))
+}
+
+// Duration returns the duration of the source, or zero if it's unknown
+func (runner *StreamRunner) Duration() time.Duration {
+	return time.Duration(runner.duration.Load())
+}
+
+func (runner *StreamRunner) IsSlate() bool {
+	return runner.itemNo == 0
+}
+
+func (runner *StreamRunner) IsStalled() bool {
+	return runner.stalled.Load()
+}
+
+func (runner *StreamRunner) Done() <-chan struct{} {
+	return runner.exited
+}
+
 func (runner *StreamRunner) IsRunning() bool {
-	return !runner.done.Load()
+	select {
+	case <-runner.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (runner *StreamRunner) Progress() *Progress {
+	return runner.progress.Latest()
+}
+
+// Position returns the start position offset by the output time
+// (which starts at zero because of -copyts -start_at_zero)
+func (runner *StreamRunner) Position() time.Duration {
+	if progress := runner.Progress(); progress != nil {
+		return runner.startPos + progress.OutTime
+	}
+	return runner.startPos
+}
+
+func (runner *StreamRunner) Uptime() time.Duration {
+	return time.Since(runner.started)
+}
+
+func (runner *StreamRunner) ExitErr() error {
+	if runner.IsRunning() || runner.stoppedCleanly() {
+		return nil
+	}
+	return runner.exitErr
+}
+
+func (runner *StreamRunner) stoppedCleanly() bool {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	return runner.stopped
 }
 
 func (runner *StreamRunner) Err() error {
-	if runner.done.Load() {
-		errStr := runner.errBuf.String()
+	if !runner.IsRunning() && !runner.stoppedCleanly() {
+		var lines []string
+		for _, line := range runner.Stream.logs.Since(runner.logStart) {
+			lines = append(lines, line.Text)
+		}
+		errStr := strings.Join(lines, "\n")
 		if len(errStr) > 128 {
 			errStr = "..." + errStr[len(errStr)-128:]
 		}
 		if len(errStr) > 0 {
 			return fmt.Errorf("%s", errStr)
 		}
+		return runner.exitErr
 	}
 	return nil
 }
 
-func (runner *StreamRunner) Close() error {
-	runner.cancel()
-	return <-runner.errChan
+// Close asks ffmpeg to quit by sending SIGINT to its process group, so it can
+// finalize its outputs, and kills the group if it's still running after the grace period
+func (runner *StreamRunner) Close(grace time.Duration) error {
+	runner.mu.Lock()
+	runner.closing = true
+	process := runner.cmd.Process
+	runner.mu.Unlock()
+	if process == nil || !runner.IsRunning() {
+		return nil // never started or already exited
+	}
+	if err := signalGroup(process.Pid, syscall.SIGINT); err == nil {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-runner.exited:
+			runner.mu.Lock()
+			runner.stopped = true
+			runner.mu.Unlock()
+			return nil
+		case <-timer.C:
+		}
+	}
+	signalGroup(process.Pid, syscall.SIGKILL)
+	<-runner.exited
+	return runner.exitErr
+}
+
+func isLogLevel(level string) bool {
+	for _, l := range logLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+func isLiveSource(source string) bool {
+	scheme, _, ok := strings.Cut(source, "://")
+	if !ok {
+		return false
+	}
+	scheme = strings.ToLower(scheme)
+	for _, s := range liveSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
 }
 
-func ffmpegArgs(stream *Stream) (args []string) {
+func ffmpegArgs(stream *Stream, item *PlaylistItem, startpos time.Duration) (args []string) {
+	loglevel := stream.LogLevel
+	if len(loglevel) == 0 {
+		loglevel = "error"
+	}
 	args = append(args,
-		"-hide_banner", "-loglevel", "error",
-		"-copyts", "-start_at_zero", "-preset", "ultrafast")
+		"-hide_banner", "-loglevel", loglevel, "-nostats", "-progress", "pipe:1",
+		"-copyts", "-start_at_zero")
 	readrate := float32(stream.ReadRate) / 100.
 	if readrate < 1. {
 		readrate = 1.
 	}
 	args = append(args, "-readrate", fmt.Sprint(readrate))
-	if stream.StartPosition > 0 {
-		startpos := fmt.Sprint(stream.StartPosition.Seconds())
-		args = append(args, "-ss", startpos, "-i", stream.Source, "-ss", startpos)
+	if item.Loops != 0 {
+		args = append(args, "-stream_loop", fmt.Sprint(item.Loops))
+	}
+	if startpos > 0 {
+		startpos := fmt.Sprint(startpos.Seconds())
+		args = append(args, "-ss", startpos, "-i", item.Source, "-ss", startpos)
 	} else {
-		args = append(args, "-i", stream.Source)
+		args = append(args, "-i", item.Source)
 	}
-	if stream.VideoChannel >= 0 {
-		args = append(args, "-c:v", "libx264", "-map", fmt.Sprintf("0:v:%d", stream.VideoChannel))
+	profile := stream.profiles.Get(stream.Profile)
+	if profile == nil {
+		profile = newDefaultProfile() // deleted in the meantime
 	}
-	if stream.AudioChannel >= 0 {
-		args = append(args, "-c:a", "aac", "-map", fmt.Sprintf("0:a:%d", stream.AudioChannel))
+	copyVideo, copyAudio := stream.copyTracks(item)
+	if item.VideoChannel >= 0 {
+		if copyVideo {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args, profile.videoArgs()...)
+		}
+		args = append(args, "-map", fmt.Sprintf("0:v:%d", item.VideoChannel))
 	}
-	if stream.SubtitleChannel >= 0 {
-		escapedSource := strings.ReplaceAll(stream.Source, ":", "\\:")
-		args = append(args, "-vf", fmt.Sprintf("subtitles='%s':stream_index=%d", escapedSource, stream.SubtitleChannel))
+	if item.AudioChannel >= 0 {
+		if copyAudio {
+			args = append(args, "-c:a", "copy")
+		} else {
+			args = append(args, profile.audioArgs()...)
+		}
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", item.AudioChannel))
 	}
-	target := stream.Target
-	if !strings.HasSuffix(target, "/") {
-		target += "/"
+	if filters := videoFilters(&stream.StreamEntry, item); item.VideoChannel >= 0 && len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
 	}
-	args = append(args, "-f", "rtsp", "-rtsp_transport", "tcp", "-auth_type", "digest", target+stream.Name)
+	args = append(args, outputArgs(stream)...)
 	return
 }
@@ -7,8 +7,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	generic_sync "github.com/SaveTheRbtz/generic-sync-map-go"
@@ -18,59 +23,151 @@ import (
 )
 
 var (
-	ErrNotFound = fmt.Errorf("not found")
+	ErrNotFound     = fmt.Errorf("not found")
+	ErrShuttingDown = fmt.Errorf("shutting down")
+)
+
+const (
+	persistInterval  = 10 * time.Second
+	scheduleInterval = time.Second
 )
 
 type StreamEntry struct {
-	Name            string        `json:"name"`
-	Source          string        `json:"source"`
-	StartPosition   time.Duration `json:"startpos"`
-	VideoChannel    int           `json:"video"`
-	AudioChannel    int           `json:"audio"`
-	SubtitleChannel int           `json:"subtitle"`
-	ReadRate        int           `json:"readrate"`
+	Name            string         `json:"name"`
+	Source          string         `json:"source"`
+	StartPosition   time.Duration  `json:"startpos"`
+	VideoChannel    int            `json:"video"`
+	AudioChannel    int            `json:"audio"`
+	SubtitleChannel int            `json:"subtitle"`
+	ReadRate        int            `json:"readrate"`
+	Width           int            `json:"width"`
+	Height          int            `json:"height"`
+	Fit             VideoFit       `json:"fit"`
+	FPS             string         `json:"fps"`
+	Rotate          string         `json:"rotate"`
+	Profile         string         `json:"profile"`
+	Encoding        EncodingMode   `json:"encoding"`
+	Priority        int            `json:"priority"`
+	RestartPolicy   RestartPolicy  `json:"restart"`
+	MaxRetries      int            `json:"maxretries"`
+	StallTimeout    time.Duration  `json:"stalltimeout"`
+	StartSchedule   string         `json:"startsched"`
+	StopSchedule    string         `json:"stopsched"`
+	Playlist        []PlaylistItem `json:"playlist,omitempty"`
+	Shuffle         bool           `json:"shuffle"`
+	Repeat          bool           `json:"repeat"`
+	EndPolicy       EndPolicy      `json:"endpolicy"`
+	LoopCount       int            `json:"loops"`
+	SlateSource     string         `json:"slate"`
+	Target          string         `json:"target"`
+	OutputPath      string         `json:"path"`
+	Outputs         []string       `json:"outputs,omitempty"`
+	SRTMode         string         `json:"srtmode"`
+	SRTLatency      time.Duration  `json:"srtlatency"`
+	SRTPassphrase   string         `json:"srtpassphrase"`
+	SRTKeyLength    int            `json:"srtpbkeylen"`
+	UDPPacketSize   int            `json:"pktsize"`
+	UDPTTL          int            `json:"ttl"`
+	UDPLocalAddr    string         `json:"localaddr"`
+	ServiceName     string         `json:"servicename"`
+	ServiceProvider string         `json:"serviceprovider"`
+	ServiceID       int            `json:"serviceid"`
+	PMTStartPID     int            `json:"pmtpid"`
+	StartPID        int            `json:"startpid"`
+	HLS             HLSMode        `json:"hls"`
+	LogLevel        string         `json:"loglevel"`
+}
+
+// streamRecord is what gets stored in db: the stream definition plus its desired run state
+type streamRecord struct {
+	StreamEntry
+	Running       bool          `json:"running"`
+	Position      time.Duration `json:"position"`
+	PlaylistOrder []int         `json:"order,omitempty"`
+	PlaylistIndex int           `json:"index"`
 }
 
 type StreamView struct {
 	StreamEntry
-	Status  string
-	Actions []string
+	OutputStatus   []OutputStatus
+	HLSPlaylist    string
+	State          StreamState
+	StateSince     time.Time
+	StateTimes     map[StreamState]time.Time
+	StateDetails   string
+	Restarts       int
+	NextRetry      time.Time
+	Position       time.Duration
+	Progress       *Progress
+	PlaylistStatus *PlaylistStatus
+	NextAction     *ScheduledAction
+	Actions        []string
 }
 
 func NewStreamView(stream *Stream) *StreamView {
 	view := &StreamView{
-		StreamEntry: StreamEntry{
-			Name:            stream.Name,
-			Source:          stream.Source,
-			StartPosition:   stream.StartPosition,
-			VideoChannel:    stream.VideoChannel,
-			AudioChannel:    stream.AudioChannel,
-			SubtitleChannel: stream.SubtitleChannel,
-			ReadRate:        stream.ReadRate,
-		},
-		Status:  stream.Status(),
-		Actions: []string{"start", "stop", "clone", "delete"},
+		StreamEntry:    stream.Redacted(),
+		OutputStatus:   stream.OutputStatus(),
+		State:          stream.State(),
+		StateSince:     stream.StateSince(),
+		StateTimes:     stream.StateTimes(),
+		StateDetails:   stream.StateDetails(),
+		Restarts:       stream.Restarts(),
+		NextRetry:      stream.NextRetry(),
+		Position:       stream.Position(),
+		Progress:       stream.Progress(),
+		PlaylistStatus: stream.PlaylistStatus(),
+		NextAction:     stream.schedule.NextAction(),
+		Actions:        []string{"start", "resume", "stop", "logs", "clone", "delete"},
 	}
 	if len(view.Source) > 128 {
 		view.Source = "..." + view.Source[len(view.Source)-100:]
 	}
+	if view.HLS.Enabled() {
+		view.HLSPlaylist = hlsURL(view.Name)
+	}
 	return view
 }
 
 type StreamManager struct {
-	target  string
-	streams generic_sync.MapOf[string, *Stream]
-	db      *redis.Client
+	target     string
+	grace      time.Duration
+	maxStreams int
+	streams    generic_sync.MapOf[string, *Stream]
+	db         *redis.Client
+	pids       *PIDRegistry
+	hlsDir     string
+	profiles   *ProfileStore
+	events     EventStore
+	queueMu    sync.Mutex
+	queue      []*queuedStart
+	preempting map[*Stream]bool
+	closing    atomic.Bool
+	done       chan struct{}
 }
 
-func NewStreamManager(target string, opt *redis.Options) *StreamManager {
+func NewStreamManager(target string, grace time.Duration, maxStreams int, pidfile, hlsDir string, opt *redis.Options) *StreamManager {
 	sm := &StreamManager{
-		target: target,
+		target:     target,
+		grace:      grace,
+		maxStreams: maxStreams,
+		pids:       NewPIDRegistry(pidfile),
+		hlsDir:     hlsDir,
+		events:     newMemoryEventStore(),
+		preempting: make(map[*Stream]bool),
+		done:       make(chan struct{}),
 	}
+	sm.pids.ReapOrphans()
 	if opt != nil {
 		sm.db = redis.NewClient(opt)
+		sm.events = &redisEventStore{db: sm.db}
+	}
+	sm.profiles = NewProfileStore(sm.db)
+	if sm.db != nil {
 		sm.loadStreamsFromDB()
+		go sm.persistLoop()
 	}
+	go sm.scheduleLoop()
 	return sm
 }
 
@@ -80,55 +177,138 @@ func (sm *StreamManager) loadStreamsFromDB() {
 		log.Println("db error:", err)
 	}
 	for _, name := range streamNames {
-		var entry StreamEntry
-		entryStr, err := sm.db.Get(context.Background(), name).Result()
+		if !namePattern.MatchString(name) {
+			continue // not a stream record
+		}
+		var record streamRecord
+		recordStr, err := sm.db.Get(context.Background(), name).Result()
 		if err != nil {
 			log.Println("db error:", err)
 			continue
 		}
-		if err := json.Unmarshal([]byte(entryStr), &entry); err != nil {
+		if err := json.Unmarshal([]byte(recordStr), &record); err != nil {
 			log.Println("json error:", err)
 			continue
 		}
-		if err := sm.launchInternal(&entry); err != nil {
+		stream, err := sm.launchInternal(&record.StreamEntry)
+		if err != nil {
 			log.Println("error while adding stream to list:", err)
+			continue
 		}
+		stream.RestorePlaylistCursor(record.PlaylistOrder, record.PlaylistIndex)
+		stream.RestorePosition(record.Position)
+		if record.Running {
+			log.Printf("[%s] restoring stream at %v", name, record.Position)
+			if err := sm.startOrQueue(stream, record.Position); err != nil {
+				log.Printf("[%s] error while restoring stream: %v", name, err)
+			}
+		}
+	}
+}
+
+func (sm *StreamManager) saveStream(stream *Stream) {
+	if sm.db == nil {
+		return
+	}
+	if s, ok := sm.streams.Load(stream.Name); !ok || s != stream {
+		return // deleted in the meantime
 	}
+	sm.saveRecord(newStreamRecord(stream))
 }
 
-func (sm *StreamManager) launchInternal(entry *StreamEntry) error {
-	stream, err := NewStream(
-		entry.Name,
-		entry.Source,
-		sm.target,
-		entry.StartPosition,
-		entry.VideoChannel,
-		entry.AudioChannel,
-		entry.SubtitleChannel,
-		entry.ReadRate)
+func newStreamRecord(stream *Stream) *streamRecord {
+	record := &streamRecord{
+		StreamEntry: stream.StreamEntry,
+		Running:     stream.IsActive() || stream.IsQueued(),
+		Position:    stream.Position(),
+	}
+	if stream.IsPlaylist() {
+		record.PlaylistOrder, record.PlaylistIndex = stream.PlaylistCursor()
+	}
+	return record
+}
+
+func (sm *StreamManager) saveRecord(record *streamRecord) {
+	recordStr, err := json.Marshal(record)
 	if err != nil {
-		return err
+		log.Println("json error:", err)
+		return
 	}
-	if _, loaded := sm.streams.LoadOrStore(entry.Name, stream); loaded {
-		return fmt.Errorf("stream name already exists")
+	if err := sm.db.Set(context.Background(), record.Name, string(recordStr), 0).Err(); err != nil {
+		log.Println("error while saving stream to db:", err)
 	}
-	return nil
 }
 
-func (sm *StreamManager) Launch(entry *StreamEntry) error {
-	if err := sm.launchInternal(entry); err != nil {
-		return err
+func (sm *StreamManager) persistLoop() {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sm.streams.Range(func(name string, stream *Stream) bool {
+				sm.saveStream(stream)
+				return true
+			})
+		case <-sm.done:
+			return
+		}
 	}
-	if sm.db != nil {
-		entryStr, err := json.Marshal(entry)
-		if err != nil {
-			log.Println("json error:", err)
-			return nil
+}
+
+func (sm *StreamManager) scheduleLoop() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			sm.streams.Range(func(name string, stream *Stream) bool {
+				start, stop := stream.schedule.Due(now)
+				if stop {
+					log.Printf("[%s] scheduled stop", name)
+					sm.stop(stream, "by schedule")
+				}
+				if start {
+					log.Printf("[%s] scheduled start", name)
+					if err := sm.Start(name); err != nil {
+						log.Printf("[%s] error while starting stream: %v", name, err)
+					}
+				}
+				return true
+			})
+		case <-sm.done:
+			return
 		}
-		if err := sm.db.Set(context.Background(), entry.Name, string(entryStr), 0).Err(); err != nil {
-			log.Println("error while saving stream to db:", err)
+	}
+}
+
+func (sm *StreamManager) launchInternal(entry *StreamEntry) (*Stream, error) {
+	stream, err := NewStream(*entry, sm.target, sm.hlsDir, sm.grace, sm.pids, sm.profiles)
+	if err != nil {
+		return nil, err
+	}
+	stream.onIdle = func(*Stream) {
+		if !sm.closing.Load() {
+			sm.dispatchQueue()
 		}
 	}
+	stream.onEvent = func(stream *Stream, event *StreamEvent) {
+		sm.events.Add(stream.Name, event)
+	}
+	if _, loaded := sm.streams.LoadOrStore(entry.Name, stream); loaded {
+		return nil, fmt.Errorf("stream name already exists")
+	}
+	return stream, nil
+}
+
+func (sm *StreamManager) Launch(entry *StreamEntry) error {
+	if sm.closing.Load() {
+		return ErrShuttingDown
+	}
+	stream, err := sm.launchInternal(entry)
+	if err != nil {
+		return err
+	}
+	sm.saveStream(stream)
 	return nil
 }
 
@@ -151,23 +331,98 @@ func (sm *StreamManager) Streams() (results []*StreamView) {
 	return
 }
 
+func (sm *StreamManager) Logs(name string) ([]LogLine, error) {
+	if stream, ok := sm.streams.Load(name); ok {
+		return stream.Logs(), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (sm *StreamManager) Events(name string) ([]StreamEvent, error) {
+	if _, ok := sm.streams.Load(name); ok {
+		return sm.events.List(name), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (sm *StreamManager) Profiles() []*EncodingProfile {
+	return sm.profiles.List()
+}
+
+func (sm *StreamManager) SaveProfile(profile *EncodingProfile) (err error) {
+	sm.streams.Range(func(_ string, stream *Stream) bool {
+		if stream.Profile == profile.Name || (len(stream.Profile) == 0 && profile.Name == DefaultProfile) {
+			if err = checkProfile(&stream.StreamEntry, stream.outputURLs, profile); err != nil {
+				err = fmt.Errorf("profile is incompatible with stream %s: %w", stream.Name, err)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	return sm.profiles.Save(profile)
+}
+
+func (sm *StreamManager) DeleteProfile(name string) (err error) {
+	sm.streams.Range(func(_ string, stream *Stream) bool {
+		if stream.Profile == name {
+			err = fmt.Errorf("profile is used by stream %s", stream.Name)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+	return sm.profiles.Delete(name)
+}
+
 func (sm *StreamManager) Start(name string) error {
+	if sm.closing.Load() {
+		return ErrShuttingDown
+	}
+	if stream, ok := sm.streams.Load(name); ok {
+		err := sm.startOrQueue(stream, startFromBeginning)
+		sm.saveStream(stream)
+		return err
+	}
+	return ErrNotFound
+}
+
+// Resume starts the stream from where it was stopped instead of its start position
+func (sm *StreamManager) Resume(name string) error {
+	if sm.closing.Load() {
+		return ErrShuttingDown
+	}
 	if stream, ok := sm.streams.Load(name); ok {
-		return stream.Start()
+		err := sm.startOrQueue(stream, stream.Position())
+		sm.saveStream(stream)
+		return err
 	}
 	return ErrNotFound
 }
 
 func (sm *StreamManager) Stop(name string) error {
 	if stream, ok := sm.streams.Load(name); ok {
-		return stream.Close()
+		return sm.stop(stream, "by user")
 	}
 	return ErrNotFound
 }
 
+func (sm *StreamManager) stop(stream *Stream, reason string) error {
+	sm.dequeue(stream)
+	err := stream.CloseWithReason(reason)
+	sm.saveStream(stream)
+	return err
+}
+
 func (sm *StreamManager) Delete(name string) error {
 	if stream, ok := sm.streams.Load(name); ok {
 		sm.streams.Delete(name)
+		sm.dequeue(stream)
+		defer sm.events.Delete(name)
 		if sm.db != nil {
 			if err := sm.db.Del(context.Background(), name).Err(); err != nil {
 				log.Println("error while deleting stream from db:", err)
@@ -178,6 +433,41 @@ func (sm *StreamManager) Delete(name string) error {
 	return ErrNotFound
 }
 
+// Shutdown saves the runtime state of every stream to db (so running streams
+// get restored on the next start) and closes them in parallel
+func (sm *StreamManager) Shutdown(ctx context.Context) error {
+	if !sm.closing.CompareAndSwap(false, true) {
+		return ErrShuttingDown
+	}
+	close(sm.done)
+	var wg sync.WaitGroup
+	sm.streams.Range(func(name string, stream *Stream) bool {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sm.db != nil {
+				sm.saveRecord(newStreamRecord(stream))
+			}
+			stream.CloseWithReason("shutdown")
+		}()
+		return true
+	})
+	allClosed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allClosed)
+	}()
+	select {
+	case <-allClosed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if sm.db != nil {
+		return sm.db.Close()
+	}
+	return nil
+}
+
 func (sm *StreamManager) Pages() []*beepboop.Page {
 	return []*beepboop.Page{
 		{
@@ -195,10 +485,38 @@ func (sm *StreamManager) Pages() []*beepboop.Page {
 			ContentTemplate: template.Probe,
 			Handler:         sm.handleProbe,
 		},
+		{
+			Path:            "/stream/",
+			ContentTemplate: template.Stream,
+			Handler:         sm.handleStream,
+		},
+		{
+			Path:            "/logs/",
+			ContentTemplate: template.Logs,
+			Handler:         sm.handleLogs,
+		},
+		{
+			Path:            "/profiles",
+			ContentTemplate: template.Profiles,
+			Handler:         sm.handleProfiles,
+		},
+		{
+			Path:    "/deleteprofile/",
+			Handler: sm.handleDeleteProfile,
+		},
+		{
+			Path:           "/hls/",
+			Handler:        sm.handleHLS,
+			OnlyLogOnError: true,
+		},
 		{
 			Path:    "/start/",
 			Handler: sm.handleStart,
 		},
+		{
+			Path:    "/resume/",
+			Handler: sm.handleResume,
+		},
 		{
 			Path:    "/stop/",
 			Handler: sm.handleStop,
@@ -234,22 +552,136 @@ func (sm *StreamManager) handleLaunch(r *beepboop.PageRequest) *beepboop.View {
 		entry.AudioChannel = toInt(req.FormValue("audio"))
 		entry.SubtitleChannel = toInt(req.FormValue("subtitle"))
 		entry.ReadRate = toInt(req.FormValue("readrate"))
+		entry.Width = toInt(req.FormValue("width"))
+		entry.Height = toInt(req.FormValue("height"))
+		entry.Fit = VideoFit(req.FormValue("fit"))
+		entry.FPS = req.FormValue("fps")
+		entry.Rotate = req.FormValue("rotate")
+		entry.Profile = req.FormValue("profile")
+		entry.Encoding = EncodingMode(req.FormValue("encoding"))
+		entry.Priority = toInt(req.FormValue("priority"))
+		entry.RestartPolicy = RestartPolicy(req.FormValue("restart"))
+		entry.MaxRetries = toInt(req.FormValue("maxretries"))
+		entry.StallTimeout, _ = time.ParseDuration(req.FormValue("stalltimeout"))
+		playlist, err := ParsePlaylist(req.FormValue("playlist"), PlaylistItem{
+			VideoChannel:    entry.VideoChannel,
+			AudioChannel:    entry.AudioChannel,
+			SubtitleChannel: entry.SubtitleChannel,
+		})
+		if err != nil {
+			return r.ErrorView(err.Error(), http.StatusBadRequest)
+		}
+		entry.Playlist = playlist
+		entry.Shuffle = len(req.FormValue("shuffle")) > 0
+		entry.Repeat = len(req.FormValue("repeat")) > 0
+		entry.EndPolicy = EndPolicy(req.FormValue("endpolicy"))
+		entry.LoopCount = toInt(req.FormValue("loops"))
+		entry.SlateSource = req.FormValue("slate")
+		entry.StartSchedule = req.FormValue("startsched")
+		entry.StopSchedule = req.FormValue("stopsched")
+		entry.Target = req.FormValue("target")
+		entry.OutputPath = req.FormValue("path")
+		for _, output := range strings.Split(req.FormValue("outputs"), "\n") {
+			if output = strings.TrimSpace(output); len(output) > 0 {
+				entry.Outputs = append(entry.Outputs, output)
+			}
+		}
+		entry.SRTMode = req.FormValue("srtmode")
+		entry.SRTLatency, _ = time.ParseDuration(req.FormValue("srtlatency"))
+		entry.SRTPassphrase = req.FormValue("srtpassphrase")
+		entry.SRTKeyLength = toInt(req.FormValue("srtpbkeylen"))
+		entry.UDPPacketSize = toInt(req.FormValue("pktsize"))
+		entry.UDPTTL = toInt(req.FormValue("ttl"))
+		entry.UDPLocalAddr = req.FormValue("localaddr")
+		entry.ServiceName = req.FormValue("servicename")
+		entry.ServiceProvider = req.FormValue("serviceprovider")
+		entry.ServiceID = toInt(req.FormValue("serviceid"))
+		entry.PMTStartPID = toInt(req.FormValue("pmtpid"))
+		entry.StartPID = toInt(req.FormValue("startpid"))
+		entry.HLS = HLSMode(req.FormValue("hls"))
+		entry.LogLevel = req.FormValue("loglevel")
 		if err := sm.Launch(&entry); err != nil {
 			return r.ErrorView(err.Error(), http.StatusBadRequest)
 		}
 		return r.RedirectView("/")
 	}
-	view := &StreamEntry{
-		ReadRate: 100,
+	view := &struct {
+		*StreamEntry
+		Profiles []*EncodingProfile
+	}{
+		StreamEntry: &StreamEntry{
+			HLS:           HLSOff,
+			ReadRate:      100,
+			Profile:       DefaultProfile,
+			Encoding:      EncodingEncode,
+			RestartPolicy: RestartNever,
+			EndPolicy:     EndStop,
+			LogLevel:      "error",
+		},
+		Profiles: sm.Profiles(),
 	}
 	if req.URL.Query().Has("clone") {
-		if stream := sm.Stream(req.URL.Query().Get("clone")); stream != nil {
-			view = &stream.StreamEntry
+		if stream, ok := sm.streams.Load(req.URL.Query().Get("clone")); ok {
+			entry := stream.withoutSecrets()
+			view.StreamEntry = &entry
 		}
 	}
 	return r.Respond(view)
 }
 
+func (sm *StreamManager) handleProfiles(r *beepboop.PageRequest) *beepboop.View {
+	req := r.Request
+	if req.Method == "POST" {
+		toInt := func(str string) int {
+			val, _ := strconv.ParseInt(str, 10, 32)
+			return int(val)
+		}
+		req.ParseForm()
+		profile := &EncodingProfile{
+			Name:             req.FormValue("name"),
+			VideoCodec:       req.FormValue("vcodec"),
+			Preset:           req.FormValue("preset"),
+			Tune:             req.FormValue("tune"),
+			CRF:              toInt(req.FormValue("crf")),
+			VideoBitrate:     req.FormValue("vbitrate"),
+			MaxRate:          req.FormValue("maxrate"),
+			BufSize:          req.FormValue("bufsize"),
+			KeyframeInterval: toInt(req.FormValue("keyint")),
+			PixelFormat:      req.FormValue("pixfmt"),
+			AudioCodec:       req.FormValue("acodec"),
+			AudioBitrate:     req.FormValue("abitrate"),
+			AudioChannels:    toInt(req.FormValue("channels")),
+			SampleRate:       toInt(req.FormValue("samplerate")),
+		}
+		if err := sm.SaveProfile(profile); err != nil {
+			return r.ErrorView(err.Error(), http.StatusBadRequest)
+		}
+		return r.RedirectView("/profiles")
+	}
+	view := &struct {
+		Profiles []*EncodingProfile
+		Edit     *EncodingProfile
+	}{
+		Profiles: sm.Profiles(),
+		Edit:     newDefaultProfile(),
+	}
+	view.Edit.Name = ""
+	if req.URL.Query().Has("edit") {
+		if profile := sm.profiles.Get(req.URL.Query().Get("edit")); profile != nil {
+			view.Edit = profile
+		}
+	}
+	return r.Respond(view)
+}
+
+func (sm *StreamManager) handleDeleteProfile(r *beepboop.PageRequest) *beepboop.View {
+	name := r.RelPath
+	if err := sm.DeleteProfile(name); err != nil {
+		return handleError(r, err)
+	}
+	return r.RedirectView("/profiles")
+}
+
 func (sm *StreamManager) handleProbe(r *beepboop.PageRequest) *beepboop.View {
 	req := r.Request
 	if req.Method == "POST" {
@@ -267,6 +699,39 @@ func (sm *StreamManager) handleProbe(r *beepboop.PageRequest) *beepboop.View {
 	return nil
 }
 
+func (sm *StreamManager) handleStream(r *beepboop.PageRequest) *beepboop.View {
+	name := r.RelPath
+	stream := sm.Stream(name)
+	if stream == nil {
+		return handleError(r, ErrNotFound)
+	}
+	events, _ := sm.Events(name)
+	view := &struct {
+		*StreamView
+		Events []StreamEvent
+	}{
+		StreamView: stream,
+		Events:     events,
+	}
+	return r.Respond(view)
+}
+
+func (sm *StreamManager) handleLogs(r *beepboop.PageRequest) *beepboop.View {
+	name := r.RelPath
+	lines, err := sm.Logs(name)
+	if err != nil {
+		return handleError(r, err)
+	}
+	view := &struct {
+		Name  string
+		Lines []LogLine
+	}{
+		Name:  name,
+		Lines: lines,
+	}
+	return r.Respond(view)
+}
+
 func (sm *StreamManager) handleStart(r *beepboop.PageRequest) *beepboop.View {
 	name := r.RelPath
 	if err := sm.Start(name); err != nil {
@@ -275,6 +740,14 @@ func (sm *StreamManager) handleStart(r *beepboop.PageRequest) *beepboop.View {
 	return r.RedirectView("/")
 }
 
+func (sm *StreamManager) handleResume(r *beepboop.PageRequest) *beepboop.View {
+	name := r.RelPath
+	if err := sm.Resume(name); err != nil {
+		return handleError(r, err)
+	}
+	return r.RedirectView("/")
+}
+
 func (sm *StreamManager) handleStop(r *beepboop.PageRequest) *beepboop.View {
 	name := r.RelPath
 	if err := sm.Stop(name); err != nil {
@@ -296,6 +769,31 @@ func (sm *StreamManager) handleDelete(r *beepboop.PageRequest) *beepboop.View {
 	return r.RedirectView("/")
 }
 
+// handleHLS serves the playlists and segments of HLS streams
+func (sm *StreamManager) handleHLS(r *beepboop.PageRequest) *beepboop.View {
+	name, file, _ := strings.Cut(r.RelPath, "/")
+	if !namePattern.MatchString(name) || file != path.Base(file) {
+		return r.ErrorView("Not found", http.StatusNotFound)
+	}
+	var contentType string
+	switch path.Ext(file) {
+	case ".m3u8":
+		contentType = "application/vnd.apple.mpegurl"
+	case ".ts":
+		contentType = "video/mp2t"
+	default:
+		return r.ErrorView("Not found", http.StatusNotFound)
+	}
+	filename := filepath.Join(sm.hlsDir, name, file)
+	return r.HandlerView(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if contentType != "video/mp2t" {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.ServeFile(w, req, filename)
+	})
+}
+
 func handleError(r *beepboop.PageRequest, err error) *beepboop.View {
 	if errors.Is(err, ErrNotFound) {
 		return r.ErrorView(err.Error(), http.StatusNotFound)
@@ -10,3 +10,12 @@ var Launch string
 
 //go:embed probe.html
 var Probe string
+
+//go:embed stream.html
+var Stream string
+
+//go:embed logs.html
+var Logs string
+
+//go:embed profiles.html
+var Profiles string